@@ -0,0 +1,206 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const aclPath = "acl"
+
+// Role is the level of access a principal has to an App.
+type Role string
+
+// Roles, each one includes the permissions of the ones below it. State
+// transitions of instances, e.g. claiming and starting them, are reserved to
+// admins once an App has an ACL.
+const (
+	// RoleOwner may change and unregister the App, its env vars, envs, procs
+	// and hooks, and its ACL.
+	RoleOwner = Role("owner")
+	// RoleDeployer may register revisions, move tags, change proc attrs and
+	// register, stop, lock and unregister instances.
+	RoleDeployer = Role("deployer")
+	// RoleViewer may only read, env vars and envs are hidden from principals
+	// without a role.
+	RoleViewer = Role("viewer")
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleDeployer: 2,
+	RoleOwner:    3,
+}
+
+// ACL lists the principals allowed to access an App by Role. Apps without
+// an ACL are open to every principal.
+type ACL struct {
+	Owners    []string `json:"owners"`
+	Deployers []string `json:"deployers"`
+	Viewers   []string `json:"viewers"`
+}
+
+// Role returns the highest Role of the principal, or an empty Role if the
+// principal isn't listed.
+func (acl *ACL) Role(principal string) Role {
+	for _, r := range []struct {
+		role       Role
+		principals []string
+	}{
+		{RoleOwner, acl.Owners},
+		{RoleDeployer, acl.Deployers},
+		{RoleViewer, acl.Viewers},
+	} {
+		for _, p := range r.principals {
+			if p == principal {
+				return r.role
+			}
+		}
+	}
+	return ""
+}
+
+// Allows returns true if the principal has the given Role or a higher one.
+func (acl *ACL) Allows(principal string, role Role) bool {
+	return roleLevels[acl.Role(principal)] >= roleLevels[role]
+}
+
+// WithAdmin gives the Store the admin role, which bypasses all ACL checks.
+// Process managers and schedulers acting on behalf of all apps need it once
+// apps have ACLs.
+func WithAdmin() StoreOption {
+	return func(s *Store) {
+		s.identity.admin = true
+	}
+}
+
+// IsAdmin returns true if the Store has the admin role.
+func (s *Store) IsAdmin() bool {
+	return s.identity.admin
+}
+
+// GetACL returns the ACL of the App, or nil if the App is open.
+func (a *App) GetACL() (*ACL, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getACL(a.Name, sp)
+}
+
+// SetACL replaces the ACL of the App, a nil ACL opens the App to every
+// principal. Only owners can change the ACL of an App that has one.
+func (a *App) SetACL(acl *ACL) (*App, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	old, err := getACL(a.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeACL(a, a.Name, old, RoleOwner); err != nil {
+		return nil, err
+	}
+
+	if acl == nil {
+		err = sp.Del(a.dir.Prefix(aclPath))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+	} else {
+		f := cp.NewFile(a.dir.Prefix(aclPath), acl, new(cp.JsonCodec), sp)
+		if _, err := f.Save(); err != nil {
+			return nil, err
+		}
+	}
+	a.ACL = acl
+
+	if err := audit(a, a.dir.Prefix(aclPath), AuditSetACL, old, acl); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// authorize returns ErrUnauthorized unless the principal of s has the given
+// Role for the app, as of the latest revision.
+func authorize(s cp.Snapshotable, app string, role Role) error {
+	if identityOf(s).admin {
+		return nil
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	acl, err := getACL(app, sp)
+	if err != nil {
+		return err
+	}
+	return authorizeACL(s, app, acl, role)
+}
+
+// authorizeLifecycle returns ErrUnauthorized unless the principal of s may
+// make the state transitions of instances of the app. They are made by
+// process managers, which need the admin role for apps with an ACL.
+func authorizeLifecycle(s cp.Snapshotable, app string) error {
+	id := identityOf(s)
+	if id.admin {
+		return nil
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	acl, err := getACL(app, sp)
+	if err != nil || acl == nil {
+		return err
+	}
+	principal := id.client
+	if principal == "" {
+		principal = "anonymous"
+	}
+	return errorf(ErrUnauthorized, `%s is not admin, required to change instances of app "%s"`, principal, app)
+}
+
+// authorizeAdmin returns ErrUnauthorized unless the principal of s has the
+// admin role.
+func authorizeAdmin(s cp.Snapshotable, what string) error {
+	id := identityOf(s)
+	if id.admin {
+		return nil
+	}
+	principal := id.client
+	if principal == "" {
+		principal = "anonymous"
+	}
+	return errorf(ErrUnauthorized, `%s is not admin, required to %s`, principal, what)
+}
+
+func authorizeACL(s cp.Snapshotable, app string, acl *ACL, role Role) error {
+	id := identityOf(s)
+	if id.admin || acl == nil || acl.Allows(id.client, role) {
+		return nil
+	}
+	principal := id.client
+	if principal == "" {
+		principal = "anonymous"
+	}
+	return errorf(ErrUnauthorized, `%s is not %s of app "%s"`, principal, role, app)
+}
+
+func getACL(app string, sp cp.Snapshot) (*ACL, error) {
+	acl := &ACL{}
+	_, err := sp.GetFile(path.Join(appsPath, app, aclPath), &cp.JsonCodec{DecodedVal: acl})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return acl, nil
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"testing"
+)
+
+func aclSetup() *Store {
+	s, err := DialURI(DefaultURI, "/acl-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestACLAllows(t *testing.T) {
+	acl := &ACL{Owners: []string{"alice"}, Deployers: []string{"bob"}, Viewers: []string{"carol"}}
+
+	for _, c := range []struct {
+		principal string
+		role      Role
+		want      bool
+	}{
+		{"alice", RoleOwner, true},
+		{"alice", RoleViewer, true},
+		{"bob", RoleDeployer, true},
+		{"bob", RoleOwner, false},
+		{"carol", RoleViewer, true},
+		{"carol", RoleDeployer, false},
+		{"dave", RoleViewer, false},
+		{"", RoleViewer, false},
+	} {
+		if have := acl.Allows(c.principal, c.role); c.want != have {
+			t.Errorf("%s as %s: want %t, have %t", c.principal, c.role, c.want, have)
+		}
+	}
+}
+
+func TestAppACL(t *testing.T) {
+	s := aclSetup()
+
+	app := s.NewApp("cat", "git://cat.git", "stack")
+	app.ACL = &ACL{Owners: []string{"alice"}, Deployers: []string{"bob"}, Viewers: []string{"carol"}}
+	if _, err := app.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	as := func(client string) *App {
+		app, err := s.With(WithClient(client)).GetApp("cat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return app
+	}
+
+	if acl := as("carol").ACL; acl == nil || acl.Role("bob") != RoleDeployer {
+		t.Fatalf("expected ACL to be loaded with the app, got %#v", acl)
+	}
+
+	if _, err := s.NewRevision(as("carol"), "abc", "abc.img").Register(); !IsErrUnauthorized(err) {
+		t.Errorf("expected viewer to be denied, got %v", err)
+	}
+	if _, err := s.NewRevision(as("bob"), "abc", "abc.img").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if err := as("bob").NewTag("stable", "abc").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as("bob").SetEnvironmentVar("A", "1"); !IsErrUnauthorized(err) {
+		t.Errorf("expected deployer to be denied env changes, got %v", err)
+	}
+	if _, err := as("alice").SetEnvironmentVar("A", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := as("").Unregister(false); !IsErrUnauthorized(err) {
+		t.Errorf("expected anonymous client to be denied, got %v", err)
+	}
+	if _, err := as("carol").GetEnvironmentVar("A"); err != nil {
+		t.Errorf("expected viewer to read env vars, got %v", err)
+	}
+	if _, err := as("").EnvironmentVars(); !IsErrUnauthorized(err) {
+		t.Errorf("expected anonymous client to be denied env vars, got %v", err)
+	}
+	if _, err := as("").GetEnvs(); !IsErrUnauthorized(err) {
+		t.Errorf("expected anonymous client to be denied envs, got %v", err)
+	}
+	if _, err := as("alice").NewEnv("prod", map[string]string{"B": "2"}).Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as("").LatestEnv(); !IsErrUnauthorized(err) {
+		t.Errorf("expected anonymous client to be denied latest env, got %v", err)
+	}
+	if _, err := as("carol").LatestEnv(); err != nil {
+		t.Errorf("expected viewer to read latest env, got %v", err)
+	}
+	view := func(client string) *View {
+		c, err := s.With(WithClient(client)).FastForward()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.AtRev(c.GetSnapshot().Rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if _, err := view("").GetEnv("cat", "prod"); !IsErrUnauthorized(err) {
+		t.Errorf("expected anonymous client to be denied env at rev, got %v", err)
+	}
+	if _, err := view("").GetEnvs("cat"); !IsErrUnauthorized(err) {
+		t.Errorf("expected anonymous client to be denied envs at rev, got %v", err)
+	}
+	if _, err := view("carol").GetEnv("cat", "prod"); err != nil {
+		t.Errorf("expected viewer to read env at rev, got %v", err)
+	}
+	ins, err := s.With(WithClient("bob")).RegisterInstance("cat", "abc", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim("10.0.0.1"); !IsErrUnauthorized(err) {
+		t.Errorf("expected deployer to be denied claims, got %v", err)
+	}
+	if _, err := ins.Failed("10.0.0.1", errors.New("no")); !IsErrUnauthorized(err) {
+		t.Errorf("expected deployer to be denied state changes, got %v", err)
+	}
+	if _, err := ins.Assign("10.0.0.1"); !IsErrUnauthorized(err) {
+		t.Errorf("expected deployer to be denied assignments, got %v", err)
+	}
+	if _, err := ins.Unassign(); !IsErrUnauthorized(err) {
+		t.Errorf("expected deployer to be denied unassignments, got %v", err)
+	}
+	ins, err = s.With(WithAdmin()).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = ins.Claim("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Started("10.0.0.1", "box00.vm", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.With(WithClient("carol")).RegisterInstance("cat", "abc", "web", "default"); !IsErrUnauthorized(err) {
+		t.Errorf("expected viewer to be denied instances, got %v", err)
+	}
+
+	if _, err := as("bob").SetACL(nil); !IsErrUnauthorized(err) {
+		t.Errorf("expected deployer to be denied ACL changes, got %v", err)
+	}
+	admin, err := s.With(WithAdmin()).GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.SetACL(&ACL{Owners: []string{"dave"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as("alice").NewTag("stable", "abc").Unregister(); !IsErrUnauthorized(err) {
+		t.Errorf("expected former owner to be denied, got %v", err)
+	}
+	if err := as("dave").Unregister(true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// Hosts and addresses are used as path segments in the coordinator, which
+// doesn't allow ':'. IPv4 addresses and hostnames are stored verbatim, IPv6
+// addresses are prefixed with ip6Prefix and have their ':' replaced by '.'.
+// Addresses combine the encoded host and the port separated by the last '-':
+//
+//	10.0.0.1          -> 10.0.0.1
+//	::1               -> ip6-..1
+//	10.0.0.1:9000     -> 10.0.0.1-9000
+//	[fe80::1]:9000    -> ip6-fe80..1-9000
+const ip6Prefix = "ip6-"
+
+var reHostName = regexp.MustCompile(`^[[:alnum:]][-.[:alnum:]]*$`)
+
+// encodeHost returns the path segment for the given host.
+func encodeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+		return ip6Prefix + strings.Replace(ip.String(), ":", ".", -1), nil
+	}
+	if !reHostName.MatchString(host) {
+		return "", errorf(ErrInvalidArgument, `invalid host "%s"`, host)
+	}
+	return host, nil
+}
+
+// decodeHost returns the host for the given path segment.
+func decodeHost(name string) string {
+	if strings.HasPrefix(name, ip6Prefix) {
+		host := strings.Replace(strings.TrimPrefix(name, ip6Prefix), ".", ":", -1)
+		if ip := net.ParseIP(host); ip != nil {
+			return ip.String()
+		}
+	}
+	return name
+}
+
+// splitAddr splits an address of the form host:port or [host]:port and
+// validates both parts.
+func splitAddr(addr string) (host, port string, err error) {
+	host, port, err = net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", errorf(ErrInvalidArgument, `invalid address "%s": %s`, addr, err)
+	}
+	if _, err := encodeHost(host); err != nil {
+		return "", "", errorf(ErrInvalidArgument, `invalid address "%s": invalid host`, addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", errorf(ErrInvalidArgument, `invalid address "%s": invalid port`, addr)
+	}
+	return host, port, nil
+}
+
+// encodeAddr returns the path segment for the given address.
+func encodeAddr(addr string) (string, error) {
+	host, port, err := splitAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	name, err := encodeHost(host)
+	if err != nil {
+		return "", err
+	}
+	return name + "-" + port, nil
+}
+
+// decodeAddr returns the address for the given path segment.
+func decodeAddr(name string) (string, error) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return "", errorf(ErrInvalidArgument, `invalid address path "%s"`, name)
+	}
+	return net.JoinHostPort(decodeHost(name[:i]), name[i+1:]), nil
+}
+
+// hostName returns the path segment for host. Invalid hosts are used
+// verbatim, writing them is refused by validating with encodeHost first.
+func hostName(host string) string {
+	name, err := encodeHost(host)
+	if err != nil {
+		return host
+	}
+	return name
+}
+
+// hostPath returns the path of host below dir.
+func hostPath(dir, host string) string {
+	return path.Join(dir, hostName(host))
+}
+
+// getHosts returns the decoded hosts stored below dir.
+func getHosts(sp cp.Snapshot, dir string) ([]string, error) {
+	names, err := sp.Getdir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		names[i] = decodeHost(name)
+	}
+	return names, nil
+}
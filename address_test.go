@@ -0,0 +1,67 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+)
+
+func TestEncodeAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"10.0.0.1:9000":          "10.0.0.1-9000",
+		"box-1.example.com:9000": "box-1.example.com-9000",
+		"[::1]:9000":             "ip6-..1-9000",
+		"[fe80::1:2]:80":         "ip6-fe80..1.2-80",
+	} {
+		have, err := encodeAddr(addr)
+		if err != nil {
+			t.Errorf("%s: %s", addr, err)
+			continue
+		}
+		if want != have {
+			t.Errorf("%s: want %s, have %s", addr, want, have)
+		}
+
+		back, err := decodeAddr(have)
+		if err != nil {
+			t.Errorf("%s: %s", have, err)
+			continue
+		}
+		if back != addr {
+			t.Errorf("%s: want %s, have %s", have, addr, back)
+		}
+	}
+}
+
+func TestEncodeAddrInvalid(t *testing.T) {
+	for _, addr := range []string{
+		"",
+		"10.0.0.1",
+		"::1:9000",
+		"10.0.0.1:0",
+		"10.0.0.1:65536",
+		"10.0.0.1:http",
+		"a/b:9000",
+		"[fe80::1%eth0]:9000",
+	} {
+		if _, err := encodeAddr(addr); !IsErrInvalidArgument(err) {
+			t.Errorf("%q: expected ErrInvalidArgument, got %v", addr, err)
+		}
+	}
+}
+
+func TestDecodeHost(t *testing.T) {
+	for name, want := range map[string]string{
+		"10.0.0.1":        "10.0.0.1",
+		"ip6-..1":         "::1",
+		"ip6-box":         "ip6-box",
+		"box.example.com": "box.example.com",
+	} {
+		if have := decodeHost(name); want != have {
+			t.Errorf("%s: want %s, have %s", name, want, have)
+		}
+	}
+}
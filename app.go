@@ -8,6 +8,7 @@ package visor
 import (
 	"fmt"
 	"path"
+	"reflect"
 	"strings"
 	"time"
 
@@ -21,18 +22,31 @@ const appsPath = "apps"
 // App is the representation of a repository of coherent changes.
 type App struct {
 	dir        *cp.Dir
-	Name       string
-	RepoURL    string
-	Stack      string
-	Env        map[string]string
-	DeployType string
-	Registered time.Time
+	identity   identity
+	Name       string            `json:"name"`
+	RepoURL    string            `json:"repoUrl"`
+	Stack      string            `json:"stack"`
+	Env        map[string]string `json:"env"`
+	DeployType string            `json:"deployType"`
+	// DeployAttrs are the defaults for the deploy type, see DeployAttrs.
+	DeployAttrs *DeployAttrs `json:"deployAttrs,omitempty"`
+	// Archived is set for Apps hidden by Archive.
+	Archived *time.Time `json:"archived,omitempty"`
+	Owner    string     `json:"owner,omitempty"`
+	Team     string     `json:"team,omitempty"`
+	// Labels identify groups of apps, see GetAppsBySelector. Annotations
+	// hold arbitrary metadata for tooling.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	ACL         *ACL              `json:"acl,omitempty"`
+	Registered  time.Time         `json:"registered"`
 }
 
 // NewApp returns a new App given a name, repository url and stack.
 func (s *Store) NewApp(name string, repourl string, stack string) (app *App) {
 	app = &App{Name: name, RepoURL: repourl, Stack: stack, Env: map[string]string{}}
 	app.dir = cp.NewDir(path.Join(appsPath, app.Name), s.GetSnapshot())
+	app.identity = s.identity
 
 	return
 }
@@ -60,12 +74,17 @@ func (a *App) Register() (*App, error) {
 	if a.DeployType == "" {
 		a.DeployType = DeployLXC
 	}
-
-	v := map[string]interface{}{
-		"repo-url":    a.RepoURL,
-		"stack":       a.Stack,
-		"deploy-type": a.DeployType,
+	if err := validateLabels(a.Labels); err != nil {
+		return nil, err
+	}
+	if err := a.validateDeploy(); err != nil {
+		return nil, err
 	}
+	if err := validateStackRef(a.Stack, sp); err != nil {
+		return nil, err
+	}
+
+	v := a.attrs()
 	attrs := cp.NewFile(a.dir.Prefix("attrs"), v, new(cp.JsonCodec), sp)
 
 	attrs, err = attrs.Save()
@@ -82,6 +101,14 @@ func (a *App) Register() (*App, error) {
 		}
 	}
 
+	// The ACL is stored after the env vars, which are subject to it.
+	if a.ACL != nil {
+		acl := cp.NewFile(a.dir.Prefix(aclPath), a.ACL, new(cp.JsonCodec), sp)
+		if _, err := acl.Save(); err != nil {
+			return nil, err
+		}
+	}
+
 	reg := time.Now()
 	d, err := a.dir.Set(registeredPath, formatTime(reg))
 	if err != nil {
@@ -91,11 +118,17 @@ func (a *App) Register() (*App, error) {
 
 	a.dir = d
 
-	return a, err
+	if err := audit(a, a.dir.Name, AuditRegister, nil, v); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
-// Unregister removes the App form the global process state.
-func (a *App) Unregister() error {
+// Unregister removes the App form the global process state. It returns
+// ErrAppInUse while the App has instances which aren't terminated yet, unless
+// force is set. Forcing unregisters all of them first, running instances are
+// stopped and Unregister blocks until their pms reported them terminated.
+func (a *App) Unregister(force bool) error {
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -107,39 +140,137 @@ func (a *App) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `app "%s" not found`, a)
 	}
-	return a.dir.Join(sp).Del("/")
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return err
+	}
+
+	live, err := a.liveInstances(sp)
+	if err != nil {
+		return err
+	}
+	if len(live) > 0 {
+		if !force {
+			return errorf(ErrAppInUse, `app "%s" has %d live instances`, a.Name, len(live))
+		}
+		if err := a.terminate(live); err != nil {
+			return err
+		}
+		sp, err = sp.FastForward()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := a.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	return audit(a, a.dir.Name, AuditUnregister, nil, nil)
 }
 
-// SetStack sets the application's stack
+// SetStack sets the application's stack, see Stack for the registry it's
+// validated against.
 func (a *App) SetStack(stack string) (*App, error) {
 	a.Stack = stack
 	return a.StoreAttrs()
 }
 
-// StoreAttrs saves the current App attrs.
+// StoreAttrs saves the current App attrs. It fails with ErrStaleWrite if
+// they have been changed since the App was read, see UpdateAttrs.
 func (a *App) StoreAttrs() (*App, error) {
-	f, err := a.dir.GetFile("attrs", new(cp.JsonCodec))
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return nil, err
+	}
+	if err := validateLabels(a.Labels); err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	what := fmt.Sprintf(`attrs of app "%s"`, a.Name)
+	if err := checkUnmodified(what, a.dir.Prefix("attrs"), a.GetSnapshot(), sp); err != nil {
+		return nil, err
+	}
+	f, err := sp.GetFile(a.dir.Prefix("attrs"), new(cp.JsonCodec))
 	if err != nil {
 		return nil, err
 	}
+	old, _ := f.Value.(map[string]interface{})
+	// Apps stay on stacks which have been removed until they move, and keep
+	// deploy types predating the registry until they change them.
+	if old == nil || old["stack"] != a.Stack {
+		if err := validateStackRef(a.Stack, sp); err != nil {
+			return nil, err
+		}
+	}
+	if a.deployChanged(old) {
+		if err := a.validateDeploy(); err != nil {
+			return nil, err
+		}
+	}
 
+	v := a.attrs()
+	f.Value = v
+	f, err = f.Save()
+	if err != nil {
+		return nil, staleErr(what, err)
+	}
+	a.dir = a.dir.Join(f)
+
+	if err := audit(a, a.dir.Name, AuditSetAttrs, old, v); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *App) attrs() map[string]interface{} {
 	v := map[string]interface{}{
 		"repo-url":    a.RepoURL,
 		"stack":       a.Stack,
 		"deploy-type": a.DeployType,
 	}
-	f.Value = v
-	f, err = f.Save()
-	if err != nil {
-		return nil, err
+	if a.Owner != "" {
+		v["owner"] = a.Owner
+	}
+	if a.Team != "" {
+		v["team"] = a.Team
 	}
+	if len(a.Labels) > 0 {
+		v["labels"] = a.Labels
+	}
+	if len(a.Annotations) > 0 {
+		v["annotations"] = a.Annotations
+	}
+	if a.DeployAttrs != nil {
+		v["deploy-attrs"] = a.DeployAttrs
+	}
+	return v
+}
 
-	return a, nil
+// deployChanged returns true if the deploy type or attrs of the App differ
+// from the stored attrs.
+func (a *App) deployChanged(stored map[string]interface{}) bool {
+	if stored == nil || stored["deploy-type"] != a.DeployType {
+		return true
+	}
+	attrs, err := decodeDeployAttrs(stored["deploy-attrs"])
+	return err != nil || !reflect.DeepEqual(attrs, a.DeployAttrs)
+}
+
+func (a *App) validateDeploy() error {
+	t, err := GetDeployType(a.DeployType)
+	if err != nil {
+		return err
+	}
+	return t.ValidateApp(a.DeployAttrs)
 }
 
 // EnvironmentVars returns all set variables for this app as a map.
 func (a *App) EnvironmentVars() (vars map[string]string, err error) {
 	vars = map[string]string{}
+	if err = authorize(a, a.Name, RoleViewer); err != nil {
+		return
+	}
 
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
@@ -169,7 +300,7 @@ func (a *App) EnvironmentVars() (vars map[string]string, err error) {
 
 	for _, name := range names {
 		go func(name string) {
-			v, err := a.GetEnvironmentVar(name)
+			v, err := a.getEnvironmentVar(name)
 			if err != nil {
 				ch <- resp{err: err}
 			} else {
@@ -188,7 +319,14 @@ func (a *App) EnvironmentVars() (vars map[string]string, err error) {
 }
 
 // GetEnvironmentVar returns the value stored for the given key.
-func (a *App) GetEnvironmentVar(k string) (value string, err error) {
+func (a *App) GetEnvironmentVar(k string) (string, error) {
+	if err := authorize(a, a.Name, RoleViewer); err != nil {
+		return "", err
+	}
+	return a.getEnvironmentVar(k)
+}
+
+func (a *App) getEnvironmentVar(k string) (value string, err error) {
 	k = strings.Replace(k, "_", "-", -1)
 	val, _, err := a.dir.Get("env/" + k)
 	if err != nil {
@@ -204,6 +342,9 @@ func (a *App) GetEnvironmentVar(k string) (value string, err error) {
 
 // SetEnvironmentVar stores the value for the given key.
 func (a *App) SetEnvironmentVar(k string, v string) (*App, error) {
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return nil, err
+	}
 	d, err := a.dir.Set("env/"+strings.Replace(k, "_", "-", -1), v)
 	if err != nil {
 		return nil, err
@@ -212,11 +353,17 @@ func (a *App) SetEnvironmentVar(k string, v string) (*App, error) {
 		a.Env[k] = v
 	}
 	a.dir = d
+	if err := audit(a, a.dir.Prefix("env", k), AuditSetEnv, nil, nil); err != nil {
+		return nil, err
+	}
 	return a, nil
 }
 
 // DelEnvironmentVar removes the env variable for the given key.
 func (a *App) DelEnvironmentVar(k string) (*App, error) {
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return nil, err
+	}
 	err := a.dir.Del("env/" + strings.Replace(k, "_", "-", -1))
 	if err != nil {
 		return nil, err
@@ -226,6 +373,9 @@ func (a *App) DelEnvironmentVar(k string) (*App, error) {
 		return nil, err
 	}
 	a.dir = a.dir.Join(sp)
+	if err := audit(a, a.dir.Prefix("env", k), AuditDelEnv, nil, nil); err != nil {
+		return nil, err
+	}
 	return a, nil
 }
 
@@ -235,7 +385,10 @@ func (a *App) GetRevisions() ([]*Revision, error) {
 	if err != nil {
 		return nil, err
 	}
+	return a.getRevisions(sp)
+}
 
+func (a *App) getRevisions(sp cp.Snapshot) ([]*Revision, error) {
 	revs, err := sp.Getdir(a.dir.Prefix("revs"))
 	if err != nil {
 		return nil, err
@@ -257,11 +410,15 @@ func (a *App) GetRevisions() ([]*Revision, error) {
 }
 
 // GetProcs returns all registered Procs for the App
-func (a *App) GetProcs() (procs []*Proc, err error) {
+func (a *App) GetProcs() ([]*Proc, error) {
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
-		return
+		return nil, err
 	}
+	return a.getProcs(sp)
+}
+
+func (a *App) getProcs(sp cp.Snapshot) (procs []*Proc, err error) {
 	names, err := sp.Getdir(a.dir.Prefix(procsPath))
 	if err != nil || len(names) == 0 {
 		if cp.IsErrNoEnt(err) {
@@ -306,11 +463,9 @@ func (a *App) WatchEvent(listener chan *Event) {
 
 	go storeFromSnapshotable(a).WatchEvent(ch)
 
+	f := EventFilter{App: a.Name}
 	for e := range ch {
-		if e.Path.App != nil && *e.Path.App == a.Name {
-			listener <- e
-		}
-		if i, ok := e.Source.(*Instance); ok && i.AppName == a.Name {
+		if f.Match(e) {
 			listener <- e
 		}
 	}
@@ -326,7 +481,7 @@ func (s *Store) GetApp(name string) (*App, error) {
 	if err != nil {
 		return nil, err
 	}
-	return getApp(name, sp)
+	return getApp(name, s.join(sp))
 }
 
 // GetApps returns the list of all registered Apps.
@@ -335,6 +490,31 @@ func (s *Store) GetApps() ([]*App, error) {
 	if err != nil {
 		return nil, err
 	}
+	return getApps(s.join(sp))
+}
+
+// GetAppsBySelector returns the Apps with labels matching the selector, e.g.
+// "team=search,tier!=batch". See ParseSelector for the syntax.
+func (s *Store) GetAppsBySelector(selector string) ([]*App, error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := s.GetApps()
+	if err != nil {
+		return nil, err
+	}
+	matching := []*App{}
+	for _, app := range apps {
+		if sel.Matches(app.Labels) {
+			matching = append(matching, app)
+		}
+	}
+	return matching, nil
+}
+
+func getApps(s cp.Snapshotable) ([]*App, error) {
+	sp := s.GetSnapshot()
 	exists, _, err := sp.Exists(appsPath)
 	if err != nil || !exists {
 		return nil, err
@@ -346,12 +526,14 @@ func (s *Store) GetApps() ([]*App, error) {
 
 	apps := []*App{}
 	ch, errch := cp.GetSnapshotables(names, func(name string) (cp.Snapshotable, error) {
-		return getApp(name, sp)
+		return getApp(name, s)
 	})
 	for i := 0; i < len(names); i++ {
 		select {
 		case r := <-ch:
-			apps = append(apps, r.(*App))
+			if app := r.(*App); !app.IsArchived() {
+				apps = append(apps, app)
+			}
 		case err := <-errch:
 			return nil, err
 		}
@@ -376,6 +558,14 @@ func getApp(name string, s cp.Snapshotable) (*App, error) {
 	app.RepoURL = value["repo-url"].(string)
 	app.Stack = value["stack"].(string)
 	app.DeployType = value["deploy-type"].(string)
+	app.Owner, _ = value["owner"].(string)
+	app.Team, _ = value["team"].(string)
+	app.Labels = stringMap(value["labels"])
+	app.Annotations = stringMap(value["annotations"])
+	app.DeployAttrs, err = decodeDeployAttrs(value["deploy-attrs"])
+	if err != nil {
+		return nil, err
+	}
 
 	f, err = app.dir.GetFile(registeredPath, new(cp.StringCodec))
 	if err != nil {
@@ -389,5 +579,37 @@ func getApp(name string, s cp.Snapshotable) (*App, error) {
 		return nil, err
 	}
 
+	app.ACL, err = getACL(app.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err = app.dir.GetFile(archivedPath, new(cp.StringCodec))
+	if err == nil {
+		archived, err := parseTime(f.Value.(string))
+		if err != nil {
+			return nil, err
+		}
+		app.Archived = &archived
+	} else if !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
 	return app, nil
 }
+
+// stringMap converts a decoded JSON object to a map of strings, it returns
+// nil for anything else.
+func stringMap(v interface{}) map[string]string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	res := map[string]string{}
+	for k, e := range m {
+		if str, ok := e.(string); ok {
+			res[k] = str
+		}
+	}
+	return res
+}
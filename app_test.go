@@ -6,8 +6,11 @@
 package visor
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func appSetup(name string) (*Store, *App) {
@@ -123,7 +126,7 @@ func TestAppUnregister(t *testing.T) {
 		return
 	}
 
-	err = app.Unregister()
+	err = app.Unregister(false)
 	if err != nil {
 		t.Error(err)
 		return
@@ -152,13 +155,13 @@ func TestAppUnregistrationFailure(t *testing.T) {
 		return
 	}
 
-	err = app.Unregister()
+	err = app.Unregister(false)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	err = app.Unregister()
+	err = app.Unregister(false)
 	if err == nil {
 		t.Error("App not present still unregistered")
 	}
@@ -199,7 +202,7 @@ func TestStoreAttrs(t *testing.T) {
 
 	app.RepoURL = "http://derphub.com"
 	app.Stack = "stack"
-	app.DeployType = "awesome"
+	app.DeployType = DeployOCI
 
 	_, err = app.StoreAttrs()
 	if err != nil {
@@ -347,3 +350,214 @@ func TestApps(t *testing.T) {
 		}
 	}
 }
+
+func TestAppMetadata(t *testing.T) {
+	s, app := appSetup("labelled-cat")
+	app.Owner = "alice"
+	app.Team = "search"
+	app.Labels = map[string]string{"team": "search", "tier": "web"}
+	app.Annotations = map[string]string{"docs": "http://wiki/cat"}
+	if _, err := app.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewApp("batch-cat", "git://cat.git", "whiskers").Register(); err != nil {
+		t.Fatal(err)
+	}
+	other := s.NewApp("other-cat", "git://cat.git", "whiskers")
+	other.Labels = map[string]string{"team": "search", "tier": "batch"}
+	if _, err := other.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	app1, err := s.GetApp("labelled-cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app1.Owner != "alice" || app1.Team != "search" {
+		t.Errorf("expected owner and team to be stored, got %#v", app1)
+	}
+	if want, have := "web", app1.Labels["tier"]; want != have {
+		t.Errorf("want label %s, have %s", want, have)
+	}
+	if want, have := "http://wiki/cat", app1.Annotations["docs"]; want != have {
+		t.Errorf("want annotation %s, have %s", want, have)
+	}
+
+	apps, err := s.GetAppsBySelector("team=search,tier!=batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].Name != "labelled-cat" {
+		t.Errorf("expected only labelled-cat to match, got %v", apps)
+	}
+
+	if _, err := s.GetAppsBySelector("team=,="); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid selector to be rejected, got %v", err)
+	}
+}
+
+func TestAppUnregisterLiveInstances(t *testing.T) {
+	s, app := appSetup("busy-dog")
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance(app.Name, "abc", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.RegisterPm("10.0.0.1", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := s.RegisterInstance(app.Name, "abc", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err = running.Claim("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err = running.Started("10.0.0.1", "box00.vm", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Unregister(false); !IsErrAppInUse(err) {
+		t.Fatalf("expected unregister to be refused, got %v", err)
+	}
+	if _, err := app.Archive(); !IsErrAppInUse(err) {
+		t.Fatalf("expected archive to be refused, got %v", err)
+	}
+
+	// The pm stops the running instance, which has to be registered until it
+	// exited. Errors of Exited itself are ignored, it races with the removal
+	// of the app once the status is written.
+	stopped := make(chan error, 1)
+	go func() {
+		ins, err := running.WaitStop()
+		if err == nil {
+			_, err = s.GetInstance(ins.ID)
+		}
+		stopped <- err
+		if err == nil {
+			ins.Exited("10.0.0.1")
+		}
+	}()
+	if err := app.Unregister(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("expected running instance to be kept until stopped, got %v", err)
+	}
+	if _, err := s.GetInstance(ins.ID); !IsErrNotFound(err) {
+		t.Errorf("expected pending instance to be unregistered, got %v", err)
+	}
+	instances, err := s.GetInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range instances {
+		if i.AppName == app.Name {
+			t.Errorf("expected no instance of the app to remain, got %s", i)
+		}
+	}
+}
+
+func TestAppArchive(t *testing.T) {
+	s, app := appSetup("old-dog")
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewRevision(app, "abc", "abc.img").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err = app.Archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := s.GetApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range apps {
+		if a.Name == app.Name {
+			t.Fatal("expected archived app to be hidden")
+		}
+	}
+	archived, err := s.GetArchivedApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || !archived[0].IsArchived() {
+		t.Fatalf("expected archived app to be listed, got %v", archived)
+	}
+	if _, err := archived[0].GetRevision("abc"); err != nil {
+		t.Errorf("expected revisions to be kept, got %v", err)
+	}
+
+	purged, err := s.PurgeArchivedApps(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 0 {
+		t.Errorf("expected app within retention to be kept, got %v", purged)
+	}
+
+	app, err = app.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Restore(); !IsErrInvalidState(err) {
+		t.Errorf("expected restoring twice to fail, got %v", err)
+	}
+	app, err = s.GetApp(app.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.IsArchived() {
+		t.Error("expected app to be restored")
+	}
+	b, err := json.Marshal(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"archived"`) {
+		t.Errorf("expected archived to be omitted for restored app, got %s", b)
+	}
+
+	if _, err := app.Archive(); err != nil {
+		t.Fatal(err)
+	}
+	purged, err = s.PurgeArchivedApps(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 1 || purged[0] != app.Name {
+		t.Errorf("expected app to be purged, got %v", purged)
+	}
+}
+
+func TestAppUnregisterUnreadableInstance(t *testing.T) {
+	s, app := appSetup("lost-dog")
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An instance without object file can't be told apart from a live one.
+	if _, err := sp.Set(instancePath(424242)+"/status", string(InsStatusRunning)); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Unregister(false); !IsErrNotFound(err) {
+		t.Errorf("expected unreadable instance to fail the unregister, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const archivedPath = "archived"
+
+// IsArchived returns true if the App has been archived.
+func (a *App) IsArchived() bool {
+	return a.Archived != nil
+}
+
+// Archive hides the App from GetApps while keeping its revisions, envs and
+// history until it's restored or purged. Like Unregister it returns
+// ErrAppInUse while the App has live instances.
+func (a *App) Archive() (*App, error) {
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	live, err := a.liveInstances(sp)
+	if err != nil {
+		return nil, err
+	}
+	if len(live) > 0 {
+		return nil, errorf(ErrAppInUse, `app "%s" has %d live instances`, a.Name, len(live))
+	}
+
+	archived := time.Now()
+	d, err := a.dir.Join(sp).Set(archivedPath, formatTime(archived))
+	if err != nil {
+		return nil, err
+	}
+	a.Archived = &archived
+	a.dir = d
+
+	if err := audit(a, a.dir.Name, AuditArchive, nil, formatTime(archived)); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Restore makes an archived App visible again.
+func (a *App) Restore() (*App, error) {
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	exists, _, err := sp.Exists(a.dir.Prefix(archivedPath))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errorf(ErrInvalidState, `app "%s" isn't archived`, a.Name)
+	}
+	if err := a.dir.Join(sp).Del(archivedPath); err != nil {
+		return nil, err
+	}
+	a.Archived = nil
+
+	sp, err = sp.FastForward()
+	if err != nil {
+		return nil, err
+	}
+	a.dir = a.dir.Join(sp)
+
+	if err := audit(a, a.dir.Name, AuditRestore, nil, nil); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetArchivedApps returns the Apps hidden by Archive.
+func (s *Store) GetArchivedApps() ([]*App, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	names, err := sp.Getdir(appsPath)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []*App{}, nil
+		}
+		return nil, err
+	}
+	apps := []*App{}
+	for _, name := range names {
+		app, err := getApp(name, s.join(sp))
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if app.IsArchived() {
+			apps = append(apps, app)
+		}
+	}
+	return apps, nil
+}
+
+// PurgeArchivedApps unregisters the Apps archived for longer than the
+// retention period and returns their names.
+func (s *Store) PurgeArchivedApps(retention time.Duration) ([]string, error) {
+	apps, err := s.GetArchivedApps()
+	if err != nil {
+		return nil, err
+	}
+	purged := []string{}
+	for _, app := range apps {
+		if time.Since(*app.Archived) < retention {
+			continue
+		}
+		if err := app.Unregister(false); err != nil {
+			return purged, err
+		}
+		purged = append(purged, app.Name)
+	}
+	return purged, nil
+}
+
+// liveInstances returns the instances of the App which aren't terminated.
+// All instances are scanned, to also catch the ones of unregistered procs.
+// Instances which can't be read are skipped only if they have been
+// unregistered since.
+func (a *App) liveInstances(sp cp.Snapshot) ([]*Instance, error) {
+	ids, err := getdirAt(sp, instancesPath)
+	if err != nil {
+		return nil, err
+	}
+	store := storeFromSnapshotable(a).join(sp)
+	live := []*Instance{}
+	for _, idstr := range ids {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return nil, err
+		}
+		ins, err := getInstance(id, store)
+		if err != nil {
+			if !IsErrNotFound(err) && !cp.IsErrNoEnt(err) {
+				return nil, err
+			}
+			latest, ferr := sp.FastForward()
+			if ferr != nil {
+				return nil, ferr
+			}
+			exists, _, ferr := latest.Exists(instancePath(id))
+			if ferr != nil {
+				return nil, ferr
+			}
+			if exists {
+				return nil, err
+			}
+			continue
+		}
+		if ins.AppName != a.Name {
+			continue
+		}
+		switch ins.Status {
+		case InsStatusPending, InsStatusClaimed, InsStatusRunning, InsStatusStopping:
+			live = append(live, ins)
+		}
+	}
+	return live, nil
+}
+
+// terminate requests the running instances to stop and unregisters all
+// instances, so none of them outlive the App in the registry. Instances
+// handled by a pm are unregistered once it reported them exited, failed or
+// lost, unregistering them earlier would leave their processes behind.
+func (a *App) terminate(instances []*Instance) error {
+	client := identityOf(a).client
+	if client == "" {
+		client = "visor"
+	}
+	reason := errors.New("app unregistered")
+
+	for _, ins := range instances {
+		if ins.Status == InsStatusRunning {
+			err := ins.Stop()
+			if err != nil && !IsErrNotFound(err) && !IsErrInvalidState(err) {
+				return err
+			}
+		}
+	}
+	for _, ins := range instances {
+		if ins.Status == InsStatusRunning || ins.Status == InsStatusStopping {
+			var err error
+			ins, err = waitTerminated(ins)
+			if IsErrNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := ins.Unregister(client, reason); err != nil && !IsErrNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitTerminated blocks until the Instance exited, failed or got lost.
+func waitTerminated(ins *Instance) (*Instance, error) {
+	for {
+		switch ins.Status {
+		case InsStatusExited, InsStatusFailed, InsStatusLost:
+			return ins, nil
+		}
+		var err error
+		ins, err = ins.WaitStatus()
+		if err != nil {
+			return nil, err
+		}
+		if ins.Status == "" {
+			return nil, errorf(ErrNotFound, "%s has been unregistered", ins)
+		}
+	}
+}
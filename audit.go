@@ -0,0 +1,286 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const auditPath = "/audit"
+
+// AuditOp is the operation an AuditRecord was written for.
+type AuditOp string
+
+// AuditOps.
+const (
+	AuditRegister   = AuditOp("register")
+	AuditUnregister = AuditOp("unregister")
+	AuditSetAttrs   = AuditOp("set-attrs")
+	AuditSetEnv     = AuditOp("set-env")
+	AuditDelEnv     = AuditOp("del-env")
+	AuditSetACL     = AuditOp("set-acl")
+	AuditArchive    = AuditOp("archive")
+	AuditRestore    = AuditOp("restore")
+	AuditRename     = AuditOp("rename")
+	AuditClaim      = AuditOp("claim")
+	AuditUnclaim    = AuditOp("unclaim")
+	AuditAssign     = AuditOp("assign")
+	AuditStart      = AuditOp("start")
+	AuditRestart    = AuditOp("restart")
+	AuditStop       = AuditOp("stop")
+	AuditFail       = AuditOp("fail")
+	AuditLose       = AuditOp("lose")
+	AuditExit       = AuditOp("exit")
+	AuditLock       = AuditOp("lock")
+	AuditUnlock     = AuditOp("unlock")
+)
+
+// AuditRecord describes a single mutation of the domain model.
+type AuditRecord struct {
+	// Rev is the first revision the mutation was observed at.
+	Rev  int64     `json:"rev"`
+	Time time.Time `json:"time"`
+	// Client is the identity of the Store the mutation was made through,
+	// empty for anonymous clients.
+	Client string  `json:"client"`
+	Object string  `json:"object"`
+	Op     AuditOp `json:"op"`
+	// Old and New hold the previous and new value of the object, where they
+	// are known without extra reads, e.g. proc attrs, tag refs and instance
+	// states. The values of env vars aren't recorded.
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+func (r *AuditRecord) String() string {
+	client := r.Client
+	if client == "" {
+		client = "anonymous"
+	}
+	return fmt.Sprintf("AuditRecord<%d %s %s %s>", r.Rev, client, r.Op, r.Object)
+}
+
+// AuditQuery selects AuditRecords, zero fields match all records.
+type AuditQuery struct {
+	Client string
+	// Object matches the object and everything below it, e.g. "apps/cat"
+	// matches the app as well as its procs, envs and tags.
+	Object  string
+	Op      AuditOp
+	FromRev int64
+	ToRev   int64
+	Since   time.Time
+	Until   time.Time
+	// Limit returns only the most recent records if greater than 0.
+	Limit int
+}
+
+// Match returns true if the record is selected by the query.
+func (q AuditQuery) Match(r *AuditRecord) bool {
+	switch {
+	case q.Client != "" && q.Client != r.Client:
+		return false
+	case q.Object != "" && r.Object != q.Object && !strings.HasPrefix(r.Object, q.Object+"/"):
+		return false
+	case q.Op != "" && q.Op != r.Op:
+		return false
+	case q.FromRev > 0 && r.Rev < q.FromRev:
+		return false
+	case q.ToRev > 0 && r.Rev > q.ToRev:
+		return false
+	case !q.Since.IsZero() && r.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && r.Time.After(q.Until):
+		return false
+	}
+	return true
+}
+
+// GetAuditRecords returns the AuditRecords matching the query, oldest first.
+func (s *Store) GetAuditRecords(q AuditQuery) ([]*AuditRecord, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	names, err := sp.Getdir(auditPath)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []*AuditRecord{}, nil
+		}
+		return nil, err
+	}
+
+	keys := auditKeys{}
+	for _, name := range names {
+		k, err := parseAuditKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if (q.FromRev > 0 && k.rev < q.FromRev) || (q.ToRev > 0 && k.rev > q.ToRev) {
+			continue
+		}
+		// The key holds the time of the record, records out of range aren't
+		// read.
+		t := time.Unix(0, k.nsec)
+		if (!q.Since.IsZero() && t.Before(q.Since)) || (!q.Until.IsZero() && t.After(q.Until)) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+
+	records := []*AuditRecord{}
+	for _, k := range keys {
+		r := &AuditRecord{}
+		_, err := sp.GetFile(path.Join(auditPath, k.name), &cp.JsonCodec{DecodedVal: r})
+		if err != nil {
+			return nil, err
+		}
+		if q.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[len(records)-q.Limit:]
+	}
+	return records, nil
+}
+
+// PruneAuditRecords removes the AuditRecords written before the given time
+// and returns how many were removed. The audit log isn't pruned otherwise,
+// so it should be run periodically, e.g. with a retention of a few months.
+// Only admins can prune.
+func (s *Store) PruneAuditRecords(before time.Time) (int, error) {
+	if err := authorizeAdmin(s, "prune audit records"); err != nil {
+		return 0, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return 0, err
+	}
+	names, err := getdirAt(sp, auditPath)
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, name := range names {
+		k, err := parseAuditKey(name)
+		if err != nil {
+			return pruned, err
+		}
+		if !time.Unix(0, k.nsec).Before(before) {
+			continue
+		}
+		err = sp.Del(path.Join(auditPath, name))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// audit appends a record for the mutation of object made through s. The old
+// and new values are optional.
+func audit(s cp.Snapshotable, object string, op AuditOp, oldVal, newVal interface{}) error {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	r := &AuditRecord{
+		Rev:    sp.Rev,
+		Time:   time.Now(),
+		Client: identityOf(s).client,
+		Object: strings.TrimPrefix(object, "/"),
+		Op:     op,
+		Old:    oldVal,
+		New:    newVal,
+	}
+	name := fmt.Sprintf("%d-%d", r.Rev, r.Time.UnixNano())
+	_, err = cp.NewFile(path.Join(auditPath, name), r, new(cp.JsonCodec), sp).Save()
+	return err
+}
+
+// identity is the client on whose behalf the domain model is mutated, see
+// WithClient and WithAdmin. It's carried from the Store to the objects obtained from it.
+type identity struct {
+	client string
+	admin  bool
+	keys   KeyProvider
+}
+
+func identityOf(s cp.Snapshotable) identity {
+	switch o := s.(type) {
+	case *Store:
+		return o.identity
+	case *View:
+		return o.identity
+	case *App:
+		return o.identity
+	case *Instance:
+		return o.identity
+	case *Stack:
+		return o.identity
+	case *Revision:
+		return identityOfApp(o.App)
+	case *Proc:
+		return identityOfApp(o.App)
+	case *Env:
+		return identityOfApp(o.App)
+	case *Tag:
+		return identityOfApp(o.App)
+	case *Hook:
+		return identityOfApp(o.App)
+	}
+	return identity{}
+}
+
+func identityOfApp(a *App) identity {
+	if a == nil {
+		return identity{}
+	}
+	return a.identity
+}
+
+type auditKey struct {
+	name string
+	rev  int64
+	nsec int64
+}
+
+func parseAuditKey(name string) (auditKey, error) {
+	parts := strings.SplitN(name, "-", 2)
+	if len(parts) != 2 {
+		return auditKey{}, errorf(ErrInvalidFile, "invalid audit record %q", name)
+	}
+	rev, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return auditKey{}, errorf(ErrInvalidFile, "invalid audit record %q", name)
+	}
+	nsec, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return auditKey{}, errorf(ErrInvalidFile, "invalid audit record %q", name)
+	}
+	return auditKey{name: name, rev: rev, nsec: nsec}, nil
+}
+
+type auditKeys []auditKey
+
+func (k auditKeys) Len() int      { return len(k) }
+func (k auditKeys) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k auditKeys) Less(i, j int) bool {
+	if k[i].rev != k[j].rev {
+		return k[i].rev < k[j].rev
+	}
+	return k[i].nsec < k[j].nsec
+}
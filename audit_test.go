@@ -0,0 +1,179 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func auditSetup(client string) *Store {
+	s, err := DialURI(DefaultURI, "/audit-test", WithClient(client))
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestAuditRecords(t *testing.T) {
+	s := auditSetup("alice")
+
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewRevision(app, "abc", "abc.img").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewRevision(app, "def", "def.img").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewTag("stable", "abc").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	bob := s.With(WithClient("bob"))
+	app, err = bob.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewTag("stable", "def").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.SetEnvironmentVar("A", "1"); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := bob.RegisterInstance("cat", "def", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = bob.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.GetAuditRecords(AuditQuery{Object: "apps/cat/tags/stable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 tag records, got %v", records)
+	}
+	if want, have := "alice", records[0].Client; want != have {
+		t.Errorf("want client %s, have %s", want, have)
+	}
+	r := records[1]
+	if r.Client != "bob" || r.Op != AuditRegister || r.Old != "abc" || r.New != "def" {
+		t.Errorf("unexpected tag move record %#v", r)
+	}
+	if records[0].Rev >= r.Rev {
+		t.Errorf("expected records ordered by rev: %v", records)
+	}
+
+	records, err = s.GetAuditRecords(AuditQuery{Client: "bob", Object: "instances"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Op != AuditRegister || records[1].Op != AuditClaim {
+		t.Errorf("expected register and claim of the instance, got %v", records)
+	}
+
+	records, err = s.GetAuditRecords(AuditQuery{Client: "bob", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Op != AuditClaim {
+		t.Errorf("expected only the most recent record, got %v", records)
+	}
+
+	records, err = s.GetAuditRecords(AuditQuery{Op: AuditSetEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Object != "apps/cat/env/A" || records[0].New != nil {
+		t.Errorf("expected env var record without value, got %#v", records)
+	}
+
+	records, err = s.GetAuditRecords(AuditQuery{Op: AuditSetEnv, Since: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records in the future, got %v", records)
+	}
+}
+
+func TestAuditQueryMatch(t *testing.T) {
+	r := &AuditRecord{Rev: 10, Client: "alice", Object: "apps/cat/procs/web", Op: AuditSetAttrs}
+
+	for _, c := range []struct {
+		q    AuditQuery
+		want bool
+	}{
+		{AuditQuery{}, true},
+		{AuditQuery{Object: "apps/cat"}, true},
+		{AuditQuery{Object: "apps/ca"}, false},
+		{AuditQuery{Client: "bob"}, false},
+		{AuditQuery{Op: AuditSetAttrs}, true},
+		{AuditQuery{FromRev: 11}, false},
+		{AuditQuery{FromRev: 5, ToRev: 10}, true},
+		{AuditQuery{ToRev: 9}, false},
+	} {
+		if have := c.q.Match(r); c.want != have {
+			t.Errorf("%#v: want %t, have %t", c.q, c.want, have)
+		}
+	}
+}
+
+func TestPruneAuditRecords(t *testing.T) {
+	s := auditSetup("alice")
+
+	if _, err := s.NewApp("cat", "git://cat.git", "stack").Register(); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	if _, err := s.NewApp("dog", "git://dog.git", "stack").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.PruneAuditRecords(before); !IsErrUnauthorized(err) {
+		t.Errorf("expected ErrUnauthorized without admin role, got %v", err)
+	}
+	pruned, err := s.With(WithAdmin()).PruneAuditRecords(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned == 0 {
+		t.Error("expected records of cat to be pruned")
+	}
+	records, err := s.GetAuditRecords(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if r.Time.Before(before) || r.Object == "apps/cat" {
+			t.Errorf("expected record to be pruned: %s", r)
+		}
+	}
+	if len(records) == 0 {
+		t.Error("expected records of dog to be kept")
+	}
+}
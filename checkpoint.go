@@ -0,0 +1,72 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Checkpointer persists the revision of the last processed Event, so event
+// consumers can resume where they stopped.
+type Checkpointer interface {
+	// Load returns the last saved revision or 0 if there is none.
+	Load() (int64, error)
+	// Save stores the given revision.
+	Save(rev int64) error
+}
+
+// FileCheckpoint is a Checkpointer storing the revision in a local file.
+type FileCheckpoint struct {
+	Path string
+}
+
+// NewFileCheckpoint returns a FileCheckpoint for the given path.
+func NewFileCheckpoint(path string) *FileCheckpoint {
+	return &FileCheckpoint{Path: path}
+}
+
+// Load satisfies the Checkpointer interface.
+func (c *FileCheckpoint) Load() (int64, error) {
+	b, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	rev, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, errorf(ErrInvalidFile, "invalid checkpoint %s: %s", c.Path, err)
+	}
+	return rev, nil
+}
+
+// Save satisfies the Checkpointer interface.
+func (c *FileCheckpoint) Save(rev int64) error {
+	return writeFileAtomic(c.Path, []byte(strconv.FormatInt(rev, 10)+"\n"))
+}
+
+// WatchEventCheckpoint resumes watching events after the revision loaded from
+// c, or from the current revision if there is no checkpoint yet. Consumers
+// call c.Save with the Rev of every Event they finished processing.
+func (s *Store) WatchEventCheckpoint(c Checkpointer, listener chan *Event, filter ...EventType) error {
+	return s.watchEventCheckpoint(c, listener, filter, nil)
+}
+
+func (s *Store) watchEventCheckpoint(c Checkpointer, listener chan *Event, filter []EventType, done <-chan struct{}) error {
+	rev, err := c.Load()
+	if err != nil {
+		return err
+	}
+	sp := s.GetSnapshot()
+	if rev != 0 {
+		sp.Rev = rev
+	}
+	return watchEvent(sp, listener, filter, done)
+}
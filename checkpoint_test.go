@@ -0,0 +1,69 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewFileCheckpoint(filepath.Join(dir, "rev"))
+
+	rev, err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 0 {
+		t.Errorf("expected missing checkpoint to load as 0, got %d", rev)
+	}
+
+	if err := c.Save(4711); err != nil {
+		t.Fatal(err)
+	}
+	rev, err = c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := int64(4711), rev; want != have {
+		t.Errorf("want rev %d, have %d", want, have)
+	}
+
+	if err := ioutil.WriteFile(c.Path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Load(); !IsErrInvalidFile(err) {
+		t.Errorf("expected ErrInvalidFile, got %v", err)
+	}
+}
+
+func TestWatchEventCheckpointCompacted(t *testing.T) {
+	s, l := eventSetup()
+
+	dir, err := ioutil.TempDir("", "checkpoint-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A checkpoint before the coordinator's history, see
+	// TestEventWatchFromCompacted.
+	c := NewFileCheckpoint(filepath.Join(dir, "rev"))
+	if err := c.Save(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WatchEventCheckpoint(c, l); !IsErrRevCompacted(err) {
+		t.Errorf("expected resuming from compacted checkpoint to fail, got %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"sort"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// CloneOptions select the parts of an App copied by Clone. The app attrs,
+// labels, annotations and ACL are always copied.
+type CloneOptions struct {
+	EnvVars   bool
+	Revisions bool
+	Envs      bool
+	// Procs are registered with fresh ports and the attrs of the original.
+	Procs bool
+	// Tags requires Revisions, as tags can only reference known revisions.
+	Tags  bool
+	Hooks bool
+}
+
+// CloneReport lists what has been copied by Clone or moved by Rename.
+type CloneReport struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	EnvVars   []string `json:"envVars"`
+	Revisions []string `json:"revisions"`
+	Envs      []string `json:"envs"`
+	Procs     []string `json:"procs"`
+	Tags      []string `json:"tags"`
+	Hooks     []string `json:"hooks"`
+	// Instances holds the ids of the instances rewritten by Rename.
+	Instances []int64 `json:"instances"`
+}
+
+func newCloneReport(from, to string) *CloneReport {
+	return &CloneReport{
+		From:      from,
+		To:        to,
+		EnvVars:   []string{},
+		Revisions: []string{},
+		Envs:      []string{},
+		Procs:     []string{},
+		Tags:      []string{},
+		Hooks:     []string{},
+		Instances: []int64{},
+	}
+}
+
+// Clone registers a new App with the given name as a copy of the App and
+// the parts selected by opts. Instances aren't copied.
+func (a *App) Clone(name string, opts CloneOptions) (*App, *CloneReport, error) {
+	if opts.Tags && !opts.Revisions {
+		return nil, nil, errorf(ErrInvalidArgument, "cloning tags requires cloning revisions")
+	}
+	if err := authorize(a, a.Name, RoleViewer); err != nil {
+		return nil, nil, err
+	}
+	s, err := storeFromSnapshotable(a).FastForward()
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err = getApp(a.Name, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := newCloneReport(a.Name, name)
+
+	clone := s.NewApp(name, a.RepoURL, a.Stack)
+	clone.DeployType = a.DeployType
+	clone.DeployAttrs = a.DeployAttrs
+	clone.Owner = a.Owner
+	clone.Team = a.Team
+	clone.Labels = a.Labels
+	clone.Annotations = a.Annotations
+	if opts.EnvVars {
+		clone.Env, err = a.EnvironmentVars()
+		if err != nil {
+			return nil, nil, err
+		}
+		for k := range clone.Env {
+			report.EnvVars = append(report.EnvVars, k)
+		}
+		sort.Strings(report.EnvVars)
+	}
+	clone, err = clone.Register()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Revisions {
+		revs, err := a.GetRevisions()
+		if err != nil {
+			return nil, report, err
+		}
+		for _, r := range revs {
+			rev := s.NewRevision(clone, r.Ref, r.ArchiveURL)
+			rev.DeployAttrs = r.DeployAttrs
+			if _, err := rev.Register(); err != nil {
+				return nil, report, err
+			}
+			report.Revisions = append(report.Revisions, r.Ref)
+		}
+	}
+	if opts.Envs {
+		sp, err := a.GetSnapshot().FastForward()
+		if err != nil {
+			return nil, report, err
+		}
+		// Envs are registered in the same order, so the clone has the same
+		// LatestEnv.
+		envs, err := a.getEnvsByRegistered(sp)
+		if err != nil {
+			return nil, report, err
+		}
+		for _, e := range envs {
+			env := clone.NewEnv(e.Ref, e.Vars)
+			env.sealed = e.sealed
+			if _, err := env.Register(); err != nil {
+				return nil, report, err
+			}
+			report.Envs = append(report.Envs, e.Ref)
+		}
+	}
+	if opts.Procs {
+		procs, err := a.GetProcs()
+		if err != nil {
+			return nil, report, err
+		}
+		for _, p := range procs {
+			proc, err := s.NewProc(clone, p.Name).Register()
+			if err != nil {
+				return nil, report, err
+			}
+			proc.Attrs = p.Attrs
+			if _, err := proc.StoreAttrs(); err != nil {
+				return nil, report, err
+			}
+			report.Procs = append(report.Procs, p.Name)
+		}
+	}
+	if opts.Tags {
+		tags, err := a.GetTags()
+		if err != nil {
+			return nil, report, err
+		}
+		for _, t := range tags {
+			if err := clone.NewTag(t.Name, t.Ref).Register(); err != nil {
+				return nil, report, err
+			}
+			report.Tags = append(report.Tags, t.Name)
+		}
+	}
+	if opts.Hooks {
+		hooks, err := a.GetHooks()
+		if err != nil {
+			return nil, report, err
+		}
+		for _, h := range hooks {
+			if _, err := clone.NewHook(h.Name, h.Script).Register(); err != nil {
+				return nil, report, err
+			}
+			report.Hooks = append(report.Hooks, h.Name)
+		}
+	}
+
+	// The ACL is set last, it might not permit the steps above.
+	if a.ACL != nil {
+		clone, err = clone.SetACL(a.ACL)
+		if err != nil {
+			return nil, report, err
+		}
+	}
+
+	return clone, report, nil
+}
+
+// Rename moves the App and everything stored with it to the given name,
+// keeping the ports of its procs, and rewrites the object files of its
+// instances. Rename isn't atomic, instances changing state while it runs
+// may be left behind.
+func (a *App) Rename(name string) (*App, *CloneReport, error) {
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return nil, nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := getApp(a.Name, sp); err != nil {
+		return nil, nil, err
+	}
+	to := path.Join(appsPath, name)
+	exists, _, err := sp.Exists(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exists {
+		return nil, nil, errorf(ErrConflict, `app "%s" already exists`, name)
+	}
+
+	// The registered file is copied last for the event system to see a
+	// complete app.
+	if err := copyTree(sp, a.dir.Name, to, registeredPath); err != nil {
+		return nil, nil, err
+	}
+	reg, _, err := sp.Get(a.dir.Prefix(registeredPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := sp.Set(path.Join(to, registeredPath), reg); err != nil {
+		return nil, nil, err
+	}
+
+	report := newCloneReport(a.Name, name)
+	instances, err := getInstances(storeFromSnapshotable(a).join(sp))
+	if err != nil && !cp.IsErrNoEnt(err) && !IsErrNotFound(err) {
+		return nil, nil, err
+	}
+	for _, ins := range instances {
+		if ins.AppName != a.Name {
+			continue
+		}
+		ins.AppName = name
+		object := cp.NewFile(ins.dir.Prefix(objectPath), ins.objectArray(), new(cp.ListCodec), sp)
+		if _, err := object.Save(); err != nil {
+			return nil, report, err
+		}
+		report.Instances = append(report.Instances, ins.ID)
+	}
+
+	sp, err = sp.FastForward()
+	if err != nil {
+		return nil, report, err
+	}
+	if err := a.dir.Join(sp).Del("/"); err != nil {
+		return nil, report, err
+	}
+	if err := audit(a, a.dir.Name, AuditRename, a.Name, name); err != nil {
+		return nil, report, err
+	}
+
+	sp, err = sp.FastForward()
+	if err != nil {
+		return nil, report, err
+	}
+	renamed, err := getApp(name, storeFromSnapshotable(a).join(sp))
+	if err != nil {
+		return nil, report, err
+	}
+	for dir, names := range map[string]*[]string{
+		revsPath:  &report.Revisions,
+		envsPath:  &report.Envs,
+		procsPath: &report.Procs,
+		tagsPath:  &report.Tags,
+		hooksPath: &report.Hooks,
+	} {
+		*names, err = getdirAt(sp, renamed.dir.Prefix(dir))
+		if err != nil {
+			return nil, report, err
+		}
+	}
+	vars, err := renamed.EnvironmentVars()
+	if err != nil {
+		return nil, report, err
+	}
+	for k := range vars {
+		report.EnvVars = append(report.EnvVars, k)
+	}
+	sort.Strings(report.EnvVars)
+
+	return renamed, report, nil
+}
+
+// copyTree copies the files below from to the same paths below to, except
+// the top-level files named in skip.
+func copyTree(sp cp.Snapshot, from, to string, skip ...string) error {
+	names, err := sp.Getdir(from)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if containsString(skip, name) {
+			continue
+		}
+		src, dst := path.Join(from, name), path.Join(to, name)
+
+		if _, err := sp.Getdir(src); err == nil {
+			if err := copyTree(sp, src, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		val, _, err := sp.Get(src)
+		if err != nil {
+			return err
+		}
+		if _, err := sp.Set(dst, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func cloneSetup() (*Store, *App) {
+	s, err := DialURI(DefaultURI, "/clone-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+
+	app := s.NewApp("cat", "git://cat.git", "stack")
+	app.Env = map[string]string{"A_B": "1"}
+	app.Labels = map[string]string{"team": "search"}
+	app, err = app.Register()
+	if err != nil {
+		panic(err)
+	}
+	if _, err := s.NewRevision(app, "abc", "abc.img").Register(); err != nil {
+		panic(err)
+	}
+	if _, err := app.NewEnv("prod", map[string]string{"B": "2"}).Register(); err != nil {
+		panic(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		panic(err)
+	}
+	proc.Attrs.TrafficControl = &TrafficControl{Share: 50}
+	if _, err := proc.StoreAttrs(); err != nil {
+		panic(err)
+	}
+	if err := app.NewTag("stable", "abc").Register(); err != nil {
+		panic(err)
+	}
+	if _, err := app.NewHook("deploy", "echo").Register(); err != nil {
+		panic(err)
+	}
+	return s, app
+}
+
+func TestAppClone(t *testing.T) {
+	s, app := cloneSetup()
+
+	if _, _, err := app.Clone("kitten", CloneOptions{Tags: true}); !IsErrInvalidArgument(err) {
+		t.Errorf("expected tags without revisions to be rejected, got %v", err)
+	}
+
+	clone, report, err := app.Clone("kitten", CloneOptions{EnvVars: true, Revisions: true, Procs: true, Tags: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &CloneReport{
+		From:      "cat",
+		To:        "kitten",
+		EnvVars:   []string{"A_B"},
+		Revisions: []string{"abc"},
+		Envs:      []string{},
+		Procs:     []string{"web"},
+		Tags:      []string{"stable"},
+		Hooks:     []string{},
+		Instances: []int64{},
+	}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("want report %#v, have %#v", want, report)
+	}
+
+	if want, have := "search", clone.Labels["team"]; want != have {
+		t.Errorf("want label %s, have %s", want, have)
+	}
+	orig, err := app.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := clone.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc.Port == orig.Port {
+		t.Error("expected cloned proc to claim a fresh port")
+	}
+	if proc.Attrs.TrafficControl == nil || proc.Attrs.TrafficControl.Share != 50 {
+		t.Errorf("expected proc attrs to be copied, got %#v", proc.Attrs)
+	}
+	if _, err := clone.GetEnv("prod"); !IsErrNotFound(err) {
+		t.Errorf("expected envs not to be copied, got %v", err)
+	}
+	if _, err := s.GetApp("cat"); err != nil {
+		t.Errorf("expected original app to be kept, got %v", err)
+	}
+}
+
+func TestAppRename(t *testing.T) {
+	s, app := cloneSetup()
+
+	ins, err := s.RegisterInstance("cat", "abc", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := app.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.NewApp("dog", "git://dog.git", "stack").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := app.Rename("dog"); !IsErrConflict(err) {
+		t.Errorf("expected rename to an existing app to fail, got %v", err)
+	}
+
+	renamed, report, err := app.Rename("tiger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []int64{ins.ID}, report.Instances; !reflect.DeepEqual(want, have) {
+		t.Errorf("want instances %v, have %v", want, have)
+	}
+	for _, names := range [][]string{report.Revisions, report.Envs, report.Procs, report.Tags, report.Hooks, report.EnvVars} {
+		if len(names) != 1 {
+			t.Errorf("expected everything to be moved, got %#v", report)
+		}
+	}
+
+	proc, err := renamed.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := orig.Port, proc.Port; want != have {
+		t.Errorf("want port %d to be kept, have %d", want, have)
+	}
+	ins, err = s.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "tiger", ins.AppName; want != have {
+		t.Errorf("want instance app %s, have %s", want, have)
+	}
+	instances, err := proc.GetInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Errorf("expected instance to be listed with the renamed proc, got %v", instances)
+	}
+	if _, err := s.GetApp("cat"); !IsErrNotFound(err) {
+		t.Errorf("expected old app to be gone, got %v", err)
+	}
+}
+
+func TestAppCloneOCI(t *testing.T) {
+	s, _ := cloneSetup()
+
+	app := s.NewApp("dog", "git://dog.git", "stack")
+	app.DeployType = DeployOCI
+	app.DeployAttrs = &DeployAttrs{Volumes: []string{"/data:/data:ro"}}
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := s.NewRevision(app, "abc", "")
+	rev.DeployAttrs = &DeployAttrs{Image: "dog:abc", Entrypoint: []string{"/bin/dog"}}
+	if _, err := rev.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	clone, _, err := app.Clone("puppy", CloneOptions{Revisions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(app.DeployAttrs, clone.DeployAttrs) {
+		t.Errorf("want deploy attrs %#v, have %#v", app.DeployAttrs, clone.DeployAttrs)
+	}
+	cloned, err := clone.GetRevision("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rev.DeployAttrs, cloned.DeployAttrs) {
+		t.Errorf("want revision deploy attrs %#v, have %#v", rev.DeployAttrs, cloned.DeployAttrs)
+	}
+}
+
+func TestAppCloneLatestEnv(t *testing.T) {
+	_, app := cloneSetup()
+
+	if _, err := app.NewEnv("canary", map[string]string{"B": "3"}).Register(); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := app.LatestEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone, _, err := app.Clone("kitten", CloneOptions{Envs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloned, err := clone.LatestEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := latest.Ref, cloned.Ref; want != have {
+		t.Errorf("want latest env %s, have %s", want, have)
+	}
+}
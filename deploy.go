@@ -0,0 +1,223 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// DeployOCI defines the canonical name for the OCI (docker) deploy type.
+const DeployOCI = "oci"
+
+const deployAttrsPath = "deploy-attrs"
+
+var reImageRef = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?::[0-9]+)?(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*(?::[\w][\w.-]{0,127})?(?:@sha256:[a-f0-9]{64})?$`)
+
+// DeployAttrs are the deploy type specific attributes. Apps hold defaults
+// which are overlaid by the attributes of the Revision being deployed.
+type DeployAttrs struct {
+	Image      string   `json:"image,omitempty"`
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	// Volumes are bind mounts in the form "/host/path:/container/path[:ro]".
+	Volumes []string `json:"volumes,omitempty"`
+}
+
+// MergeDeployAttrs returns the attributes of app overlaid by the ones set
+// on rev. Both may be nil.
+func MergeDeployAttrs(app, rev *DeployAttrs) *DeployAttrs {
+	merged := &DeployAttrs{}
+	for _, attrs := range []*DeployAttrs{app, rev} {
+		if attrs == nil {
+			continue
+		}
+		if attrs.Image != "" {
+			merged.Image = attrs.Image
+		}
+		if len(attrs.Entrypoint) > 0 {
+			merged.Entrypoint = attrs.Entrypoint
+		}
+		if len(attrs.Volumes) > 0 {
+			merged.Volumes = attrs.Volumes
+		}
+	}
+	return merged
+}
+
+// DeployType validates the attributes of apps and revisions deployed with
+// it.
+type DeployType struct {
+	Name string
+	// ValidateApp checks the defaults stored with an App.
+	ValidateApp func(attrs *DeployAttrs) error
+	// ValidateRevision checks the attributes instances of a Revision are
+	// deployed with, the App's merged with the Revision's.
+	ValidateRevision func(attrs *DeployAttrs) error
+}
+
+var deployTypes = struct {
+	sync.RWMutex
+	m map[string]DeployType
+}{m: map[string]DeployType{}}
+
+func init() {
+	RegisterDeployType(DeployType{
+		Name:             DeployLXC,
+		ValidateApp:      validateLXCAttrs,
+		ValidateRevision: validateLXCAttrs,
+	})
+	RegisterDeployType(DeployType{
+		Name:             DeployOCI,
+		ValidateApp:      validateOCIAttrs,
+		ValidateRevision: validateOCIRevisionAttrs,
+	})
+}
+
+// RegisterDeployType adds the deploy type to the registry, replacing the
+// one with the same name.
+func RegisterDeployType(t DeployType) {
+	deployTypes.Lock()
+	defer deployTypes.Unlock()
+	deployTypes.m[t.Name] = t
+}
+
+// GetDeployType returns the registered deploy type with the given name.
+func GetDeployType(name string) (DeployType, error) {
+	deployTypes.RLock()
+	defer deployTypes.RUnlock()
+	t, ok := deployTypes.m[name]
+	if !ok {
+		return DeployType{}, errorf(ErrBadDeployType, `unknown deploy type "%s"`, name)
+	}
+	return t, nil
+}
+
+// DeployTypes returns the names of all registered deploy types.
+func DeployTypes() []string {
+	deployTypes.RLock()
+	defer deployTypes.RUnlock()
+	names := []string{}
+	for name := range deployTypes.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateLXCAttrs(attrs *DeployAttrs) error {
+	if attrs != nil && (attrs.Image != "" || len(attrs.Volumes) > 0) {
+		return errorf(ErrBadDeployType, "image and volumes aren't supported by %s", DeployLXC)
+	}
+	return nil
+}
+
+func validateOCIAttrs(attrs *DeployAttrs) error {
+	if attrs == nil {
+		return nil
+	}
+	if attrs.Image != "" && !reImageRef.MatchString(attrs.Image) {
+		return errorf(ErrBadDeployType, `invalid image reference "%s"`, attrs.Image)
+	}
+	if len(attrs.Entrypoint) > 0 && attrs.Entrypoint[0] == "" {
+		return errorf(ErrBadDeployType, "entrypoint can't start with an empty argument")
+	}
+	for _, v := range attrs.Volumes {
+		parts := strings.Split(v, ":")
+		if len(parts) < 2 || len(parts) > 3 || !path.IsAbs(parts[0]) || !path.IsAbs(parts[1]) {
+			return errorf(ErrBadDeployType, `invalid volume "%s"`, v)
+		}
+		if len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw" {
+			return errorf(ErrBadDeployType, `invalid volume mode "%s"`, parts[2])
+		}
+	}
+	return nil
+}
+
+func validateOCIRevisionAttrs(attrs *DeployAttrs) error {
+	if attrs == nil || attrs.Image == "" {
+		return errorf(ErrBadDeployType, "%s requires an image", DeployOCI)
+	}
+	return validateOCIAttrs(attrs)
+}
+
+// Supports returns true if the Pm is able to run instances of the given
+// deploy type.
+func (p *Pm) Supports(deployType string) bool {
+	return containsString(p.DeployTypes, deployType)
+}
+
+// GetDeployAttrs returns the deploy type of the Instance and the attributes
+// it's deployed with.
+func (i *Instance) GetDeployAttrs() (string, *DeployAttrs, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return "", nil, err
+	}
+	app, err := getApp(i.AppName, sp)
+	if err != nil {
+		return "", nil, err
+	}
+	rev, err := getRevision(app, i.RevisionName, sp)
+	if err != nil {
+		return "", nil, err
+	}
+	return app.DeployType, MergeDeployAttrs(app.DeployAttrs, rev.DeployAttrs), nil
+}
+
+// checkPmDeployType returns ErrBadDeployType if the pm on host doesn't
+// support the deploy type of app. Unknown pms and apps, and apps with deploy
+// types predating the registry aren't checked.
+func checkPmDeployType(host, app string, s cp.Snapshotable) error {
+	pm, err := getPm(host, s)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	a, err := getApp(app, s)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !isKnownDeployType(a.DeployType) {
+		return nil
+	}
+	if !pm.Supports(a.DeployType) {
+		return errorf(ErrBadDeployType, "pm %s doesn't support deploy type %s of app %s", host, a.DeployType, app)
+	}
+	return nil
+}
+
+// isKnownDeployType returns true if the deploy type is registered.
+func isKnownDeployType(name string) bool {
+	_, err := GetDeployType(name)
+	return err == nil
+}
+
+// decodeDeployAttrs converts the decoded JSON found in app attrs.
+func decodeDeployAttrs(v interface{}) (*DeployAttrs, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	attrs := &DeployAttrs{}
+	if err := json.Unmarshal(b, attrs); err != nil {
+		return nil, errorf(ErrInvalidFile, "invalid deploy attrs: %s", err)
+	}
+	return attrs, nil
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func deploySetup() *Store {
+	s, err := DialURI(DefaultURI, "/deploy-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestDeployTypeValidation(t *testing.T) {
+	lxc, err := GetDeployType(DeployLXC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oci, err := GetDeployType(DeployOCI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetDeployType("rkt"); !IsErrBadDeployType(err) {
+		t.Errorf("expected ErrBadDeployType for unknown type, got %v", err)
+	}
+
+	for _, c := range []struct {
+		validate func(*DeployAttrs) error
+		attrs    *DeployAttrs
+		valid    bool
+	}{
+		{lxc.ValidateRevision, nil, true},
+		{lxc.ValidateApp, &DeployAttrs{Image: "cat:1"}, false},
+		{oci.ValidateApp, nil, true},
+		{oci.ValidateRevision, nil, false},
+		{oci.ValidateRevision, &DeployAttrs{Image: "registry:5000/cat:1.2"}, true},
+		{oci.ValidateRevision, &DeployAttrs{Image: "Cat:1"}, false},
+		{oci.ValidateApp, &DeployAttrs{Volumes: []string{"/data:/data:ro"}}, true},
+		{oci.ValidateApp, &DeployAttrs{Volumes: []string{"data:/data"}}, false},
+		{oci.ValidateApp, &DeployAttrs{Volumes: []string{"/data:/data:rx"}}, false},
+		{oci.ValidateApp, &DeployAttrs{Entrypoint: []string{""}}, false},
+	} {
+		err := c.validate(c.attrs)
+		if c.valid && err != nil {
+			t.Errorf("%#v: expected valid, got %s", c.attrs, err)
+		}
+		if !c.valid && !IsErrBadDeployType(err) {
+			t.Errorf("%#v: expected ErrBadDeployType, got %v", c.attrs, err)
+		}
+	}
+}
+
+func TestMergeDeployAttrs(t *testing.T) {
+	app := &DeployAttrs{Image: "cat:1", Volumes: []string{"/data:/data"}}
+	rev := &DeployAttrs{Image: "cat:2", Entrypoint: []string{"/bin/cat"}}
+
+	want := &DeployAttrs{Image: "cat:2", Entrypoint: []string{"/bin/cat"}, Volumes: []string{"/data:/data"}}
+	if have := MergeDeployAttrs(app, rev); !reflect.DeepEqual(want, have) {
+		t.Errorf("want %#v, have %#v", want, have)
+	}
+	if have := MergeDeployAttrs(nil, nil); !reflect.DeepEqual(&DeployAttrs{}, have) {
+		t.Errorf("expected empty attrs, have %#v", have)
+	}
+}
+
+func TestDeployOCI(t *testing.T) {
+	s := deploySetup()
+
+	app := s.NewApp("cat", "git://cat.git", "stack")
+	app.DeployType = "rkt"
+	if _, err := app.Register(); !IsErrBadDeployType(err) {
+		t.Errorf("expected ErrBadDeployType, got %v", err)
+	}
+	app.DeployType = DeployOCI
+	app.DeployAttrs = &DeployAttrs{Volumes: []string{"/data:/data"}}
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err = s.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"/data:/data"}, app.DeployAttrs.Volumes) {
+		t.Errorf("expected deploy attrs to be stored, got %#v", app.DeployAttrs)
+	}
+
+	if _, err := s.NewRevision(app, "abc", "").Register(); !IsErrBadDeployType(err) {
+		t.Errorf("expected revision without image to fail, got %v", err)
+	}
+	rev := s.NewRevision(app, "abc", "")
+	rev.DeployAttrs = &DeployAttrs{Image: "cat:abc"}
+	if _, err := rev.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = s.RegisterPm("10.0.0.1", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := s.NewPm("10.0.0.2", "v1")
+	pm.DeployTypes = []string{DeployLXC, DeployOCI}
+	if _, err := pm.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := s.RegisterInstance("cat", "abc", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim("10.0.0.1"); !IsErrBadDeployType(err) {
+		t.Errorf("expected claim by lxc only pm to fail, got %v", err)
+	}
+	ins, err = s.NewScheduler().Schedule(ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "10.0.0.2", ins.Assignee; want != have {
+		t.Errorf("want assignee %s, have %s", want, have)
+	}
+
+	deployType, attrs, err := ins.GetDeployAttrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployType != DeployOCI || attrs.Image != "cat:abc" || len(attrs.Volumes) != 1 {
+		t.Errorf("unexpected deploy attrs %s %#v", deployType, attrs)
+	}
+}
+
+func TestDeployLegacyType(t *testing.T) {
+	s := deploySetup()
+
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Apps registered before the registry may have any deploy type.
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"repo-url":"git://cat.git","stack":"stack","deploy-type":"awesome"}`
+	if _, err := sp.Set(app.dir.Prefix("attrs"), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err = s.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Team = "pets"
+	app, err = app.StoreAttrs()
+	if err != nil {
+		t.Fatalf("expected legacy app to keep its deploy type, got %s", err)
+	}
+	if _, err := s.NewRevision(app, "abc", "abc.img").Register(); err != nil {
+		t.Errorf("expected revisions of legacy apps to be registered, got %s", err)
+	}
+
+	s, err = s.RegisterPm("10.0.0.1", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance("cat", "abc", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim("10.0.0.1"); err != nil {
+		t.Errorf("expected instances of legacy apps to be claimed, got %s", err)
+	}
+
+	app.DeployType = "rkt"
+	if _, err := app.StoreAttrs(); !IsErrBadDeployType(err) {
+		t.Errorf("expected changed deploy type to be validated, got %v", err)
+	}
+}
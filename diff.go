@@ -0,0 +1,358 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// ChangeKind describes how an object changed between two revisions.
+type ChangeKind string
+
+// ChangeKinds.
+const (
+	ChangeAdded    = ChangeKind("added")
+	ChangeRemoved  = ChangeKind("removed")
+	ChangeModified = ChangeKind("modified")
+)
+
+// ChangeObject is the kind of object a Change refers to.
+type ChangeObject string
+
+// ChangeObjects.
+const (
+	ChangeApp       = ChangeObject("app")
+	ChangeRevision  = ChangeObject("rev")
+	ChangeProc      = ChangeObject("proc")
+	ChangeProcAttrs = ChangeObject("proc-attrs")
+	ChangeEnv       = ChangeObject("env")
+	ChangeTag       = ChangeObject("tag")
+	ChangeInstance  = ChangeObject("instance")
+)
+
+// Change is a single domain level change between two revisions.
+type Change struct {
+	Kind   ChangeKind   `json:"kind"`
+	Object ChangeObject `json:"object"`
+	App    string       `json:"app,omitempty"`
+	// Name identifies the object within the app, e.g. the rev ref, proc or
+	// tag name, or the instance id.
+	Name string `json:"name"`
+	// Field is the changed proc attrs field, e.g. "trafficControl.share", or
+	// "ref" for tags and "status" for instances.
+	Field string `json:"field,omitempty"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+func (c Change) String() string {
+	name := c.Name
+	if c.App != "" && c.Object != ChangeApp {
+		name = c.App + ":" + name
+	}
+	if c.Field != "" {
+		return fmt.Sprintf("%s %s %s %s: %q -> %q", c.Object, name, c.Kind, c.Field, c.From, c.To)
+	}
+	return fmt.Sprintf("%s %s %s", c.Object, name, c.Kind)
+}
+
+// Diff lists the Changes between two revisions.
+type Diff struct {
+	FromRev int64    `json:"fromRev"`
+	ToRev   int64    `json:"toRev"`
+	Changes []Change `json:"changes"`
+}
+
+// Diff returns the domain level changes between fromRev and toRev: apps,
+// revisions, procs, envs and tags added or removed, proc attrs changed field
+// by field, tags moved and instances transitioned. It returns
+// ErrRevCompacted if fromRev isn't available anymore.
+func (s *Store) Diff(fromRev, toRev int64) (*Diff, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	if fromRev < 1 || fromRev > toRev || toRev > sp.Rev {
+		return nil, errorf(ErrInvalidArgument, "invalid revision range %d..%d", fromRev, toRev)
+	}
+
+	from, err := loadModelState(sp, fromRev)
+	if err != nil {
+		return nil, err
+	}
+	to, err := loadModelState(sp, toRev)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{FromRev: fromRev, ToRev: toRev, Changes: []Change{}}
+	d.Changes = append(d.Changes, diffKeys(ChangeApp, from.apps, to.apps)...)
+	d.Changes = append(d.Changes, diffKeys(ChangeRevision, from.revs, to.revs)...)
+	d.Changes = append(d.Changes, diffKeys(ChangeProc, from.procs, to.procs)...)
+	d.Changes = append(d.Changes, diffKeys(ChangeEnv, from.envs, to.envs)...)
+	d.Changes = append(d.Changes, diffKeys(ChangeTag, from.tags, to.tags)...)
+	d.Changes = append(d.Changes, diffKeys(ChangeInstance, from.instances, to.instances)...)
+
+	for key, attrs := range to.procAttrs {
+		if old, ok := from.procAttrs[key]; ok {
+			d.Changes = append(d.Changes, diffFields(ChangeProcAttrs, key, old, attrs)...)
+		}
+	}
+	for key, ref := range to.tags {
+		if old, ok := from.tags[key]; ok && old != ref {
+			d.Changes = append(d.Changes, newChange(ChangeModified, ChangeTag, key, "ref", old, ref))
+		}
+	}
+	for key, status := range to.instances {
+		if old, ok := from.instances[key]; ok && old != status {
+			d.Changes = append(d.Changes, newChange(ChangeModified, ChangeInstance, key, "status", old, status))
+		}
+	}
+	sort.Sort(changesByObject(d.Changes))
+
+	return d, nil
+}
+
+// modelState holds the objects compared by Diff keyed by "<app>/<name>", or
+// the instance id, mapped to the value compared for modifications.
+type modelState struct {
+	apps      map[string]string
+	revs      map[string]string
+	procs     map[string]string
+	procAttrs map[string]map[string]string
+	envs      map[string]string
+	tags      map[string]string
+	instances map[string]string
+}
+
+func loadModelState(sp cp.Snapshot, rev int64) (*modelState, error) {
+	sp.Rev = rev
+	st := &modelState{
+		apps:      map[string]string{},
+		revs:      map[string]string{},
+		procs:     map[string]string{},
+		procAttrs: map[string]map[string]string{},
+		envs:      map[string]string{},
+		tags:      map[string]string{},
+		instances: map[string]string{},
+	}
+
+	names, err := getdirAt(sp, appsPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		app, err := getApp(name, sp)
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return nil, revErr(err, rev)
+		}
+		st.apps[name] = ""
+
+		refs, err := getdirAt(sp, app.dir.Prefix(revsPath))
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			st.revs[path.Join(name, ref)] = ""
+		}
+
+		procs, err := getdirAt(sp, app.dir.Prefix(procsPath))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range procs {
+			proc, err := getProc(app, p, sp)
+			if err != nil {
+				if IsErrNotFound(err) {
+					continue
+				}
+				return nil, revErr(err, rev)
+			}
+			key := path.Join(name, p)
+			st.procs[key] = ""
+			st.procAttrs[key], err = flattenJSON(proc.Attrs)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		envs, err := getdirAt(sp, app.dir.Prefix(envsPath))
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range envs {
+			st.envs[path.Join(name, ref)] = ""
+		}
+
+		tags, err := getdirAt(sp, app.dir.Prefix(tagsPath))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tags {
+			tag, err := getTag(app, t, sp)
+			if err != nil {
+				if IsErrNotFound(err) {
+					continue
+				}
+				return nil, revErr(err, rev)
+			}
+			st.tags[path.Join(name, t)] = tag.Ref
+		}
+	}
+
+	ids, err := getdirAt(sp, instancesPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, idstr := range ids {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return nil, err
+		}
+		ins, err := getInstance(id, sp)
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return nil, revErr(err, rev)
+		}
+		st.instances[strconv.FormatInt(id, 10)] = string(ins.Status)
+	}
+
+	return st, nil
+}
+
+// getdirAt lists dir at the snapshot's revision, missing dirs are empty.
+func getdirAt(sp cp.Snapshot, dir string) ([]string, error) {
+	names, err := sp.Getdir(dir)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []string{}, nil
+		}
+		return nil, revErr(err, sp.Rev)
+	}
+	return names, nil
+}
+
+func revErr(err error, rev int64) error {
+	if isErrTooLate(err) {
+		return errorf(ErrRevCompacted, "revision %d has been compacted", rev)
+	}
+	return err
+}
+
+func diffKeys(obj ChangeObject, from, to map[string]string) []Change {
+	changes := []Change{}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			changes = append(changes, newChange(ChangeAdded, obj, key, "", "", ""))
+		}
+	}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			changes = append(changes, newChange(ChangeRemoved, obj, key, "", "", ""))
+		}
+	}
+	return changes
+}
+
+func diffFields(obj ChangeObject, key string, from, to map[string]string) []Change {
+	changes := []Change{}
+	for field, v := range to {
+		if old, ok := from[field]; !ok || old != v {
+			changes = append(changes, newChange(ChangeModified, obj, key, field, old, v))
+		}
+	}
+	for field, old := range from {
+		if _, ok := to[field]; !ok {
+			changes = append(changes, newChange(ChangeModified, obj, key, field, old, ""))
+		}
+	}
+	return changes
+}
+
+func newChange(kind ChangeKind, obj ChangeObject, key, field, from, to string) Change {
+	c := Change{Kind: kind, Object: obj, Name: key, Field: field, From: from, To: to}
+	if obj != ChangeInstance {
+		parts := strings.SplitN(key, "/", 2)
+		c.App, c.Name = parts[0], parts[len(parts)-1]
+	}
+	return c
+}
+
+// flattenJSON encodes v as JSON and returns its leaves keyed by their dotted
+// path, e.g. "limits.memory-limit-mb".
+func flattenJSON(v interface{}) (map[string]string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	flatten("", m, fields)
+	return fields, nil
+}
+
+func flatten(prefix string, v interface{}, fields map[string]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flatten(k, e, fields)
+		}
+	case nil:
+	default:
+		b, _ := json.Marshal(t)
+		fields[prefix] = string(b)
+	}
+}
+
+var changeObjectOrder = map[ChangeObject]int{
+	ChangeApp:       0,
+	ChangeRevision:  1,
+	ChangeProc:      2,
+	ChangeProcAttrs: 3,
+	ChangeEnv:       4,
+	ChangeTag:       5,
+	ChangeInstance:  6,
+}
+
+type changesByObject []Change
+
+func (c changesByObject) Len() int      { return len(c) }
+func (c changesByObject) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c changesByObject) Less(i, j int) bool {
+	if c[i].Object != c[j].Object {
+		return changeObjectOrder[c[i].Object] < changeObjectOrder[c[j].Object]
+	}
+	if c[i].App != c[j].App {
+		return c[i].App < c[j].App
+	}
+	if c[i].Name != c[j].Name {
+		if c[i].Object == ChangeInstance {
+			a, _ := strconv.ParseInt(c[i].Name, 10, 64)
+			b, _ := strconv.ParseInt(c[j].Name, 10, 64)
+			return a < b
+		}
+		return c[i].Name < c[j].Name
+	}
+	return c[i].Field < c[j].Field
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"strconv"
+	"testing"
+)
+
+func diffSetup() *Store {
+	s, err := DialURI(DefaultURI, "/diff-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func containsChange(changes []Change, c Change) bool {
+	for _, change := range changes {
+		if change == c {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDiff(t *testing.T) {
+	s := diffSetup()
+
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"abc", "def"} {
+		if _, err := s.NewRevision(app, ref, ref+".img").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewTag("stable", "abc").Register(); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance("cat", "abc", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := s.GetSnapshot().Rev
+
+	proc.Attrs.TrafficControl = &TrafficControl{Share: 30}
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewTag("stable", "def").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.NewEnv("prod", map[string]string{"A": "1"}).Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := s.Diff(from, s.GetSnapshot().Rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []Change{
+		{Kind: ChangeModified, Object: ChangeProcAttrs, App: "cat", Name: "web", Field: "trafficControl.share", To: "30"},
+		{Kind: ChangeModified, Object: ChangeTag, App: "cat", Name: "stable", Field: "ref", From: "abc", To: "def"},
+		{Kind: ChangeAdded, Object: ChangeEnv, App: "cat", Name: "prod"},
+		{Kind: ChangeModified, Object: ChangeInstance, Name: strconv.FormatInt(ins.ID, 10), Field: "status", From: "pending", To: "claimed"},
+	} {
+		if !containsChange(d.Changes, c) {
+			t.Errorf("expected %s in %v", c, d.Changes)
+		}
+	}
+	if containsChange(d.Changes, Change{Kind: ChangeAdded, Object: ChangeApp, App: "cat", Name: "cat"}) {
+		t.Error("expected app registered before the range to be left out")
+	}
+
+	if _, err := s.Diff(s.GetSnapshot().Rev, from); !IsErrInvalidArgument(err) {
+		t.Errorf("expected reversed range to be rejected, got %v", err)
+	}
+}
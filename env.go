@@ -6,6 +6,8 @@
 package visor
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,11 +21,16 @@ const (
 
 // Env is a set of config variables which will be passed to instances.
 type Env struct {
-	dir        *cp.Dir
-	App        *App
-	Ref        string
-	Vars       map[string]string
-	Registered time.Time
+	dir  *cp.Dir
+	App  *App              `json:"-"`
+	Ref  string            `json:"ref"`
+	Vars map[string]string `json:"vars"`
+	// Secrets are encrypted at rest, see KeyProvider. They hold the plain
+	// values to register, registered Envs and the ones read from the store
+	// hold Redacted values, see DecryptSecrets.
+	Secrets    map[string]string `json:"secrets,omitempty"`
+	Registered time.Time         `json:"registered"`
+	sealed     map[string]string
 }
 
 // NewEnv returns a new Env given an App, the ref and the map of vars.
@@ -44,6 +51,9 @@ func (e *Env) GetSnapshot() cp.Snapshot {
 
 // Register adds the Env to the Apps envs.
 func (e *Env) Register() (*Env, error) {
+	if err := authorize(e, e.App.Name, RoleOwner); err != nil {
+		return nil, err
+	}
 	sp, err := e.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -58,19 +68,27 @@ func (e *Env) Register() (*Env, error) {
 	}
 
 	for k := range e.Vars {
-		if len(k) == 0 {
-			return nil, errorf(ErrInvalidKey, `env keys can't be emproc`)
-		}
-		if strings.Contains(k, "=") {
-			return nil, errorf(ErrInvalidKey, `env keys can't contain "="`)
+		if err := validateEnvKey(k); err != nil {
+			return nil, err
 		}
 	}
+	sealed, err := e.seal()
+	if err != nil {
+		return nil, err
+	}
 
 	attrs := cp.NewFile(e.dir.Prefix(varsPath), e.Vars, new(cp.JsonCodec), sp)
 	attrs, err = attrs.Save()
 	if err != nil {
 		return nil, err
 	}
+	if len(sealed) > 0 {
+		f := cp.NewFile(e.dir.Prefix(secretsPath), sealed, new(cp.JsonCodec), sp)
+		if _, err := f.Save(); err != nil {
+			return nil, err
+		}
+	}
+	e.setSealed(sealed)
 
 	reg := time.Now()
 	d, err := e.dir.Set(registeredPath, formatTime(reg))
@@ -81,11 +99,19 @@ func (e *Env) Register() (*Env, error) {
 
 	e.dir = d
 
+	if err := audit(e, e.dir.Name, AuditRegister, nil, nil); err != nil {
+		return nil, err
+	}
 	return e, nil
 }
 
-// Unregister removes the Env from the Apps envs.
-func (e *Env) Unregister() error {
+// Unregister removes the Env from the Apps envs. It returns ErrConflict
+// listing the instances which aren't terminated yet and use the Env, unless
+// force is set.
+func (e *Env) Unregister(force bool) error {
+	if err := authorize(e, e.App.Name, RoleOwner); err != nil {
+		return err
+	}
 	sp, err := e.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -97,11 +123,165 @@ func (e *Env) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `env "%s" not found`, e.Ref)
 	}
-	return e.dir.Join(sp).Del("/")
+
+	live, err := e.App.liveInstances(sp)
+	if err != nil {
+		return err
+	}
+	refs := []string{}
+	for _, ins := range live {
+		if ins.Env == e.Ref {
+			refs = append(refs, fmt.Sprintf("instance %d", ins.ID))
+		}
+	}
+	if len(refs) > 0 && !force {
+		return errorf(ErrConflict, `env "%s" is used by %s`, e.Ref, strings.Join(refs, ", "))
+	}
+	if err := e.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	return audit(e, e.dir.Name, AuditUnregister, nil, nil)
+}
+
+// Derive registers a new Env with the given ref holding the vars of the Env
+// with the vars in set added or replaced and the ones in unset removed.
+// Secrets are carried over encrypted unless they're unset. Setting a secret
+// fails with ErrInvalidKey, so it isn't turned into a plain var by accident.
+func (e *Env) Derive(ref string, set map[string]string, unset []string) (*Env, error) {
+	vars := map[string]string{}
+	for k, v := range e.Vars {
+		vars[k] = v
+	}
+	sealed := map[string]string{}
+	for k, v := range e.sealed {
+		sealed[k] = v
+	}
+	for k, v := range set {
+		if _, ok := sealed[k]; ok {
+			return nil, errorf(ErrInvalidKey, `env key "%s" is a secret of env %s`, k, e.Ref)
+		}
+		vars[k] = v
+	}
+	for _, k := range unset {
+		if _, ok := set[k]; ok {
+			return nil, errorf(ErrInvalidArgument, `env key "%s" can't be set and unset`, k)
+		}
+		delete(vars, k)
+		delete(sealed, k)
+	}
+	derived := e.App.NewEnv(ref, vars)
+	derived.sealed = sealed
+	return derived.Register()
+}
+
+// EnvDiff lists the keys which differ between two Envs, see DiffEnvs.
+type EnvDiff struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+	// AddedSecrets and RemovedSecrets list the names of secrets, changed
+	// values of secrets aren't detected.
+	AddedSecrets   []string `json:"addedSecrets"`
+	RemovedSecrets []string `json:"removedSecrets"`
+}
+
+// Empty returns true if both Envs hold the same vars and secret names.
+func (d *EnvDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 &&
+		len(d.AddedSecrets) == 0 && len(d.RemovedSecrets) == 0
+}
+
+// DiffEnvs returns the keys added, removed and changed going from Env a to
+// Env b, each sorted. Secrets are compared by name only, their values can't
+// be without decrypting them.
+func DiffEnvs(a, b *Env) *EnvDiff {
+	d := &EnvDiff{
+		From:           a.Ref,
+		To:             b.Ref,
+		Added:          []string{},
+		Removed:        []string{},
+		Changed:        []string{},
+		AddedSecrets:   []string{},
+		RemovedSecrets: []string{},
+	}
+	for k, v := range b.Vars {
+		old, ok := a.Vars[k]
+		if !ok {
+			d.Added = append(d.Added, k)
+		} else if old != v {
+			d.Changed = append(d.Changed, k)
+		}
+	}
+	for k := range a.Vars {
+		if _, ok := b.Vars[k]; !ok {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	for k := range b.sealed {
+		if _, ok := a.sealed[k]; !ok {
+			d.AddedSecrets = append(d.AddedSecrets, k)
+		}
+	}
+	for k := range a.sealed {
+		if _, ok := b.sealed[k]; !ok {
+			d.RemovedSecrets = append(d.RemovedSecrets, k)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	sort.Strings(d.AddedSecrets)
+	sort.Strings(d.RemovedSecrets)
+	return d
+}
+
+// LatestEnv returns the most recently registered Env of the App, which is
+// the one instances are scaled with unless another one is given.
+func (a *App) LatestEnv() (*Env, error) {
+	if err := authorize(a, a.Name, RoleViewer); err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	envs, err := a.getEnvsByRegistered(sp)
+	if err != nil {
+		return nil, err
+	}
+	if len(envs) == 0 {
+		return nil, errorf(ErrNotFound, `no envs registered for app %s`, a.Name)
+	}
+	return envs[len(envs)-1], nil
+}
+
+// getEnvsByRegistered returns the Envs of the App, oldest first.
+// Registration times have a resolution of seconds, ties are broken by the
+// revision the Envs were registered at.
+func (a *App) getEnvsByRegistered(sp cp.Snapshot) ([]*Env, error) {
+	envs, err := a.getEnvs(sp)
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	byRegistered := envsByRegistered{envs: envs, revs: make([]int64, len(envs))}
+	for i, e := range envs {
+		_, rev, err := sp.Get(e.dir.Prefix(registeredPath))
+		if err != nil {
+			return nil, err
+		}
+		byRegistered.revs[i] = rev
+	}
+	sort.Sort(byRegistered)
+	return envs, nil
 }
 
 // GetEnv retrieves the Env for the passed ref.
 func (a *App) GetEnv(ref string) (*Env, error) {
+	if err := authorize(a, a.Name, RoleViewer); err != nil {
+		return nil, err
+	}
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -111,11 +291,17 @@ func (a *App) GetEnv(ref string) (*Env, error) {
 
 // GetEnvs returns a list of all Envs for the app.
 func (a *App) GetEnvs() ([]*Env, error) {
+	if err := authorize(a, a.Name, RoleViewer); err != nil {
+		return nil, err
+	}
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
 	}
+	return a.getEnvs(sp)
+}
 
+func (a *App) getEnvs(sp cp.Snapshot) ([]*Env, error) {
 	refs, err := sp.Getdir(a.dir.Prefix(envsPath))
 	if err != nil {
 		return nil, err
@@ -170,5 +356,84 @@ func getEnv(app *App, ref string, s cp.Snapshotable) (*Env, error) {
 		return nil, err
 	}
 
+	sealed := map[string]string{}
+	_, err = e.dir.GetFile(secretsPath, &cp.JsonCodec{DecodedVal: &sealed})
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	e.setSealed(sealed)
+
 	return e, nil
 }
+
+// seal returns the encrypted secrets of the Env, including the ones already
+// encrypted.
+func (e *Env) seal() (map[string]string, error) {
+	sealed := map[string]string{}
+	for k, v := range e.sealed {
+		sealed[k] = v
+	}
+	if len(e.Secrets) == 0 {
+		return sealed, nil
+	}
+	keys := identityOf(e).keys
+	if keys == nil {
+		return nil, errorf(ErrNoKey, "no key provider to encrypt secrets of env %s", e.Ref)
+	}
+	for k, v := range e.Secrets {
+		if err := validateEnvKey(k); err != nil {
+			return nil, err
+		}
+		if _, ok := e.Vars[k]; ok {
+			return nil, errorf(ErrInvalidKey, `env key "%s" can't be both var and secret`, k)
+		}
+		if _, ok := sealed[k]; ok && v == Redacted {
+			continue
+		}
+		s, err := sealSecret(keys, k, v)
+		if err != nil {
+			return nil, err
+		}
+		sealed[k] = s
+	}
+	return sealed, nil
+}
+
+// setSealed stores the encrypted secrets with the Env and redacts them.
+func (e *Env) setSealed(sealed map[string]string) {
+	e.sealed = sealed
+	e.Secrets = nil
+	if len(sealed) > 0 {
+		e.Secrets = map[string]string{}
+		for k := range sealed {
+			e.Secrets[k] = Redacted
+		}
+	}
+}
+
+func validateEnvKey(k string) error {
+	if len(k) == 0 {
+		return errorf(ErrInvalidKey, `env keys can't be emproc`)
+	}
+	if strings.Contains(k, "=") {
+		return errorf(ErrInvalidKey, `env keys can't contain "="`)
+	}
+	return nil
+}
+
+type envsByRegistered struct {
+	envs []*Env
+	revs []int64
+}
+
+func (s envsByRegistered) Len() int { return len(s.envs) }
+func (s envsByRegistered) Swap(i, j int) {
+	s.envs[i], s.envs[j] = s.envs[j], s.envs[i]
+	s.revs[i], s.revs[j] = s.revs[j], s.revs[i]
+}
+func (s envsByRegistered) Less(i, j int) bool {
+	if !s.envs[i].Registered.Equal(s.envs[j].Registered) {
+		return s.envs[i].Registered.Before(s.envs[j].Registered)
+	}
+	return s.revs[i] < s.revs[j]
+}
@@ -6,6 +6,8 @@
 package visor
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -95,7 +97,7 @@ func TestEnvUnregister(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = env.Unregister()
+	err = env.Unregister(false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -113,6 +115,33 @@ func TestEnvUnregister(t *testing.T) {
 	}
 }
 
+func TestEnvUnregisterInUse(t *testing.T) {
+	app := envSetup(t)
+	env, err := app.NewEnv("4321", map[string]string{}).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := store.RegisterInstance(app.Name, "abc", "web", env.Ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.Unregister(false); !IsErrConflict(err) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	ins, err = store.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ins.Unregister("env-test", errors.New("done")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Unregister(false); err != nil {
+		t.Errorf("expected env without live instances to be unregistered, got %v", err)
+	}
+}
+
 func TestEnvKeyValidation(t *testing.T) {
 	app := envSetup(t)
 	vars := map[string]string{"": "VAL0"}
@@ -161,3 +190,61 @@ func TestEnvList(t *testing.T) {
 		t.Error("GetEnvs didn't return the same amount of envs")
 	}
 }
+
+func TestEnvDerive(t *testing.T) {
+	app := envSetup(t)
+	base, err := app.NewEnv("first", map[string]string{"A": "1", "B": "2", "C": "3"}).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = base.Derive("second", map[string]string{"A": "0"}, []string{"A"})
+	if !IsErrInvalidArgument(err) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+	derived, err := base.Derive("second", map[string]string{"A": "0", "D": "4"}, []string{"C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err = app.GetEnv("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"A": "0", "B": "2", "D": "4"}
+	if !reflect.DeepEqual(want, derived.Vars) {
+		t.Errorf("want %v, have %v", want, derived.Vars)
+	}
+	if len(base.Vars) != 3 {
+		t.Errorf("expected base env to be untouched, got %v", base.Vars)
+	}
+
+	d := DiffEnvs(base, derived)
+	if !reflect.DeepEqual([]string{"D"}, d.Added) ||
+		!reflect.DeepEqual([]string{"C"}, d.Removed) ||
+		!reflect.DeepEqual([]string{"A"}, d.Changed) {
+		t.Errorf("unexpected diff %#v", d)
+	}
+	if !DiffEnvs(derived, derived).Empty() {
+		t.Error("expected diff of an env with itself to be empty")
+	}
+}
+
+func TestAppLatestEnv(t *testing.T) {
+	app := envSetup(t)
+
+	if _, err := app.LatestEnv(); !IsErrNotFound(err) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	for _, ref := range []string{"b", "a", "c"} {
+		if _, err := app.NewEnv(ref, map[string]string{}).Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env, err := app.LatestEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "c", env.Ref; want != have {
+		t.Errorf("want latest env %s, have %s", want, have)
+	}
+}
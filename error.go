@@ -26,6 +26,13 @@ var (
 	ErrUnauthorized    = errors.New("operation is not permitted")
 	ErrNotFound        = errors.New("object not found")
 	ErrTagShadowing    = errors.New("revision already exists with tag name")
+	ErrNoCapacity      = errors.New("no pm with sufficient capacity")
+	ErrRevCompacted    = errors.New("revision is no longer available")
+	ErrSlowSubscriber  = errors.New("subscriber can't keep up with events")
+	ErrAppInUse        = errors.New("app has live instances")
+	ErrBadDeployType   = errors.New("invalid or unsupported deploy type")
+	ErrStaleWrite      = errors.New("object changed since it was read")
+	ErrNoKey           = errors.New("secret key not available")
 )
 
 // Error is the wrapper type to express custom errors.
@@ -111,6 +118,41 @@ func IsErrTagShadowing(err error) bool {
 	return unwrapErr(err) == ErrTagShadowing
 }
 
+// IsErrNoCapacity is a helper to test for ErrNoCapacity.
+func IsErrNoCapacity(err error) bool {
+	return unwrapErr(err) == ErrNoCapacity
+}
+
+// IsErrRevCompacted is a helper to test for ErrRevCompacted.
+func IsErrRevCompacted(err error) bool {
+	return unwrapErr(err) == ErrRevCompacted
+}
+
+// IsErrSlowSubscriber is a helper to test for ErrSlowSubscriber.
+func IsErrSlowSubscriber(err error) bool {
+	return unwrapErr(err) == ErrSlowSubscriber
+}
+
 func errorf(err error, format string, args ...interface{}) *Error {
 	return NewError(err, fmt.Sprintf(format, args...))
 }
+
+// IsErrAppInUse is a helper to test for ErrAppInUse.
+func IsErrAppInUse(err error) bool {
+	return unwrapErr(err) == ErrAppInUse
+}
+
+// IsErrBadDeployType is a helper to test for ErrBadDeployType.
+func IsErrBadDeployType(err error) bool {
+	return unwrapErr(err) == ErrBadDeployType
+}
+
+// IsErrStaleWrite is a helper to test for ErrStaleWrite.
+func IsErrStaleWrite(err error) bool {
+	return unwrapErr(err) == ErrStaleWrite
+}
+
+// IsErrNoKey is a helper to test for ErrNoKey.
+func IsErrNoKey(err error) bool {
+	return unwrapErr(err) == ErrNoKey
+}
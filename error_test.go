@@ -102,3 +102,70 @@ func TestIsErrInvalidPort(t *testing.T) {
 		{NewError(ErrInvalidPort, "invalid port"), true},
 	})
 }
+
+func TestIsErrNoCapacity(t *testing.T) {
+	testErrFn(t, IsErrNoCapacity, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrNoCapacity, "no capacity"), true},
+	})
+}
+
+func TestIsErrRevCompacted(t *testing.T) {
+	testErrFn(t, IsErrRevCompacted, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrRevCompacted, "revision compacted"), true},
+	})
+}
+
+func TestIsErrSlowSubscriber(t *testing.T) {
+	testErrFn(t, IsErrSlowSubscriber, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrSlowSubscriber, "overflow"), true},
+	})
+}
+
+func TestIsErrAppInUse(t *testing.T) {
+	testErrFn(t, IsErrAppInUse, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{ErrAppInUse, true},
+		{NewError(ErrAppInUse, "app in use"), true},
+	})
+}
+
+func TestIsErrBadDeployType(t *testing.T) {
+	testErrFn(t, IsErrBadDeployType, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{ErrBadDeployType, true},
+		{NewError(ErrBadDeployType, "bad deploy type"), true},
+	})
+}
+
+func TestIsErrStaleWrite(t *testing.T) {
+	testErrFn(t, IsErrStaleWrite, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{ErrConflict, false},
+		{ErrStaleWrite, true},
+		{NewError(ErrStaleWrite, "attrs changed"), true},
+	})
+}
+
+func TestIsErrNoKey(t *testing.T) {
+	testErrFn(t, IsErrNoKey, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{ErrInvalidFile, false},
+		{ErrNoKey, true},
+		{NewError(ErrNoKey, "no key"), true},
+	})
+}
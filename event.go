@@ -7,13 +7,16 @@ package visor
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"path"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 
 	cp "github.com/soundcloud/cotterpin"
+	"github.com/soundcloud/doozer"
 )
 
 // Event represents a change to a file in the registry.
@@ -27,10 +30,14 @@ type Event struct {
 
 // EventData is used to represent information encoded in the file path.
 type EventData struct {
-	App      *string
-	Instance *string
-	Proc     *string
-	Revision *string
+	App      *string `json:"app,omitempty"`
+	Env      *string `json:"env,omitempty"`
+	Hook     *string `json:"hook,omitempty"`
+	Instance *string `json:"instance,omitempty"`
+	Proc     *string `json:"proc,omitempty"`
+	Revision *string `json:"rev,omitempty"`
+	Runner   *string `json:"runner,omitempty"`
+	Tag      *string `json:"tag,omitempty"`
 }
 
 func (d EventData) String() string {
@@ -53,22 +60,36 @@ type EventType string
 
 // EventTypes.
 const (
-	EvAppReg     = EventType("app-register")
-	EvAppUnreg   = EventType("app-unregister")
-	EvRevReg     = EventType("rev-register")
-	EvRevUnreg   = EventType("rev-unregister")
-	EvProcReg    = EventType("proc-register")
-	EvProcUnreg  = EventType("proc-unregister")
-	EvProcAttrs  = EventType("proc-attrs")
-	EvInsReg     = EventType("instance-register")
-	EvInsUnclaim = EventType("instance-unclaim")
-	EvInsUnreg   = EventType("instance-unregister")
-	EvInsStart   = EventType("instance-start")
-	EvInsStop    = EventType("instance-stop")
-	EvInsFail    = EventType("instance-fail")
-	EvInsExit    = EventType("instance-exit")
-	EvInsLost    = EventType("instance-lost")
-	EvUnknown    = EventType("UNKNOWN")
+	EvAppReg      = EventType("app-register")
+	EvAppUnreg    = EventType("app-unregister")
+	EvRevReg      = EventType("rev-register")
+	EvRevUnreg    = EventType("rev-unregister")
+	EvProcReg     = EventType("proc-register")
+	EvProcUnreg   = EventType("proc-unregister")
+	EvProcAttrs   = EventType("proc-attrs")
+	EvEnvReg      = EventType("env-register")
+	EvEnvUnreg    = EventType("env-unregister")
+	EvTagReg      = EventType("tag-register")
+	EvTagUpdate   = EventType("tag-update")
+	EvTagUnreg    = EventType("tag-unregister")
+	EvHookReg     = EventType("hook-register")
+	EvHookUnreg   = EventType("hook-unregister")
+	EvRunnerReg   = EventType("runner-register")
+	EvRunnerUnreg = EventType("runner-unregister")
+	EvInsReg      = EventType("instance-register")
+	EvInsAssign   = EventType("instance-assign")
+	EvInsClaim    = EventType("instance-claim")
+	EvInsUnclaim  = EventType("instance-unclaim")
+	EvInsUnreg    = EventType("instance-unregister")
+	EvInsStart    = EventType("instance-start")
+	EvInsStop     = EventType("instance-stop")
+	EvInsFail     = EventType("instance-fail")
+	EvInsExit     = EventType("instance-exit")
+	EvInsLost     = EventType("instance-lost")
+	EvInsRestart  = EventType("instance-restart")
+	EvInsLock     = EventType("instance-lock")
+	EvInsUnlock   = EventType("instance-unlock")
+	EvUnknown     = EventType("UNKNOWN")
 )
 
 type eventPath int
@@ -78,7 +99,15 @@ const (
 	pathRev
 	pathProc
 	pathProcAttrs
+	pathEnv
+	pathTag
+	pathHook
+	pathRunner
 	pathInsRegistered
+	pathInsAssign
+	pathInsClaim
+	pathInsRestarts
+	pathInsLock
 	pathInsStatus
 	pathInsStart
 	pathInsStop
@@ -94,7 +123,15 @@ var eventPatterns = map[*regexp.Regexp]eventPath{
 	regexp.MustCompile("^/apps/(" + charPat + "+)/revs/(" + charPat + "+)/registered$"):  pathRev,
 	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/registered$"): pathProc,
 	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/attrs$"):      pathProcAttrs,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/envs/(" + charPat + "+)/registered$"):  pathEnv,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/tags/(" + charPat + "+)$"):             pathTag,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/hooks/(" + charPat + "+)$"):            pathHook,
+	regexp.MustCompile("^/runners/(" + charPat + "+)/([0-9]+)$"):                         pathRunner,
 	regexp.MustCompile("^/instances/([-0-9]+)/registered$"):                              pathInsRegistered,
+	regexp.MustCompile("^/instances/([-0-9]+)/assign$"):                                  pathInsAssign,
+	regexp.MustCompile("^/instances/([-0-9]+)/claims/(" + charPat + "+)$"):               pathInsClaim,
+	regexp.MustCompile("^/instances/([-0-9]+)/restarts$"):                                pathInsRestarts,
+	regexp.MustCompile("^/instances/([-0-9]+)/lock$"):                                    pathInsLock,
 	regexp.MustCompile("^/instances/([-0-9]+)/status$"):                                  pathInsStatus,
 	regexp.MustCompile("^/instances/([-0-9]+)/start$"):                                   pathInsStart,
 	regexp.MustCompile("^/instances/([-0-9]+)/stop$"):                                    pathInsStop,
@@ -104,16 +141,115 @@ func (ev *Event) String() string {
 	return fmt.Sprintf("%#v", ev)
 }
 
+// EventVersion is the version of the JSON encoding of Events, it's bumped on
+// incompatible changes. See doc/event-wire-format.md.
+const EventVersion = 1
+
+type eventJSON struct {
+	Version int             `json:"version"`
+	Type    EventType       `json:"type"`
+	Rev     int64           `json:"rev"`
+	Path    EventData       `json:"path"`
+	Source  json.RawMessage `json:"source,omitempty"`
+}
+
+// MarshalJSON encodes the Event in the versioned wire format.
+func (ev *Event) MarshalJSON() ([]byte, error) {
+	v := eventJSON{
+		Version: EventVersion,
+		Type:    ev.Type,
+		Rev:     ev.Rev,
+		Path:    ev.Path,
+	}
+	if ev.Source != nil && !reflect.ValueOf(ev.Source).IsNil() {
+		src, err := json.Marshal(ev.Source)
+		if err != nil {
+			return nil, err
+		}
+		v.Source = src
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes an Event from the wire format. The decoded Source is
+// detached from the coordinator, only its fields can be used.
+func (ev *Event) UnmarshalJSON(b []byte) error {
+	v := eventJSON{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Version != EventVersion {
+		return errorf(ErrInvalidArgument, "unsupported event version %d", v.Version)
+	}
+
+	*ev = Event{Type: v.Type, Rev: v.Rev, Path: v.Path}
+	if len(v.Source) == 0 || string(v.Source) == "null" {
+		return nil
+	}
+
+	var app *App
+	if v.Path.App != nil {
+		app = &App{Name: *v.Path.App}
+	}
+
+	var src cp.Snapshotable
+	switch v.Type {
+	case EvAppReg:
+		src = &App{}
+	case EvRevReg:
+		src = &Revision{App: app}
+	case EvProcReg, EvProcAttrs:
+		src = &Proc{App: app}
+	case EvEnvReg:
+		src = &Env{App: app}
+	case EvTagReg, EvTagUpdate:
+		src = &Tag{App: app}
+	case EvHookReg:
+		src = &Hook{App: app}
+	case EvRunnerReg:
+		src = &Runner{}
+	case EvInsReg, EvInsAssign, EvInsClaim, EvInsUnclaim, EvInsStart, EvInsStop,
+		EvInsFail, EvInsExit, EvInsLost, EvInsRestart, EvInsLock:
+		src = &Instance{}
+	default:
+		return errorf(ErrInvalidArgument, "unexpected source for event type %s", v.Type)
+	}
+	if err := json.Unmarshal(v.Source, src); err != nil {
+		return err
+	}
+	ev.Source = src
+
+	return nil
+}
+
 // WatchEvent watches for changes on the store, enriches them with the
 // corresponding domain object and sends them as Event object to the given
 // channel.
 // Optionally any number of EventTypes can be given in order to filter which
 // events will be sent over the given channel.
 func (s *Store) WatchEvent(listener chan *Event, filter ...EventType) error {
+	return watchEvent(s.GetSnapshot(), listener, filter, nil)
+}
+
+// WatchEventFrom works like WatchEvent, but first replays all changes after
+// the given revision. It returns ErrRevCompacted if the coordinator doesn't
+// hold the history back to rev anymore.
+func (s *Store) WatchEventFrom(rev int64, listener chan *Event, filter ...EventType) error {
 	sp := s.GetSnapshot()
+	sp.Rev = rev
+	return watchEvent(sp, listener, filter, nil)
+}
+
+// watchEvent sends Events to listener until done is closed. Waiting on the
+// coordinator can't be interrupted, so the watch ends with the next Event
+// after done has been closed.
+func watchEvent(sp cp.Snapshot, listener chan *Event, filter []EventType, done <-chan struct{}) error {
 	for {
 		ev, err := sp.Wait(globPlural)
 		if err != nil {
+			if isErrTooLate(err) {
+				err = errorf(ErrRevCompacted, "revision %d has been compacted", sp.Rev)
+			}
 			return err
 		}
 		sp = sp.Join(ev)
@@ -128,8 +264,24 @@ func (s *Store) WatchEvent(listener chan *Event, filter ...EventType) error {
 		if err := event.enrich(); err != nil {
 			return err
 		}
-		listener <- event
+		select {
+		case listener <- event:
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// isErrTooLate checks if the coordinator refused to wait for a revision
+// already dropped from its history.
+func isErrTooLate(err error) bool {
+	if e, ok := err.(*cp.Error); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*doozer.Error); ok {
+		err = e.Err
 	}
+	return err == doozer.ErrTooLate
 }
 
 func newEvent(src cp.Event) (*Event, error) {
@@ -169,6 +321,45 @@ func newEvent(src cp.Event) (*Event, error) {
 				}
 				event.Type = EvProcAttrs
 				event.Path = EventData{App: &match[1], Proc: &match[2]}
+			case pathEnv:
+				if src.IsSet() {
+					event.Type = EvEnvReg
+				} else if src.IsDel() {
+					event.Type = EvEnvUnreg
+				}
+				event.Path = EventData{App: &match[1], Env: &match[2]}
+			case pathTag:
+				if src.IsSet() {
+					// Tags are overwritten in place to move them to another
+					// revision, so an existing file means an update.
+					existed, err := pathExistedBefore(src)
+					if err != nil {
+						return nil, err
+					}
+					if existed {
+						event.Type = EvTagUpdate
+					} else {
+						event.Type = EvTagReg
+					}
+				} else if src.IsDel() {
+					event.Type = EvTagUnreg
+				}
+				event.Path = EventData{App: &match[1], Tag: &match[2]}
+			case pathHook:
+				if src.IsSet() {
+					event.Type = EvHookReg
+				} else if src.IsDel() {
+					event.Type = EvHookUnreg
+				}
+				event.Path = EventData{App: &match[1], Hook: &match[2]}
+			case pathRunner:
+				if src.IsSet() {
+					event.Type = EvRunnerReg
+				} else if src.IsDel() {
+					event.Type = EvRunnerUnreg
+				}
+				addr := addrFromPath(src.Path)
+				event.Path = EventData{Runner: &addr}
 			case pathInsRegistered:
 				if src.IsSet() {
 					event.Type = EvInsReg
@@ -176,6 +367,31 @@ func newEvent(src cp.Event) (*Event, error) {
 					event.Type = EvInsUnreg
 				}
 				event.Path = EventData{Instance: &match[1]}
+			case pathInsAssign:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsAssign
+				event.Path = EventData{Instance: &match[1]}
+			case pathInsClaim:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsClaim
+				event.Path = EventData{Instance: &match[1]}
+			case pathInsRestarts:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsRestart
+				event.Path = EventData{Instance: &match[1]}
+			case pathInsLock:
+				if src.IsSet() {
+					event.Type = EvInsLock
+				} else if src.IsDel() {
+					event.Type = EvInsUnlock
+				}
+				event.Path = EventData{Instance: &match[1]}
 			case pathInsStart:
 				if !src.IsSet() {
 					break
@@ -224,9 +440,38 @@ func newEvent(src cp.Event) (*Event, error) {
 		}
 	}
 
+	if event.Path.Instance != nil && event.Type != EvUnknown {
+		if err := event.setInstancePath(src); err != nil {
+			return nil, err
+		}
+	}
+
 	return event, nil
 }
 
+// setInstancePath fills the app, revision and proc of instance events from
+// the object file of the instance. For deletions it's read at the revision
+// before, so unregister events carry them too.
+func (e *Event) setInstancePath(src cp.Event) error {
+	sp := src.GetSnapshot()
+	if src.IsDel() {
+		sp.Rev--
+	}
+	f, err := sp.GetFile(path.Join(instancesPath, *e.Path.Instance, objectPath), new(cp.ListCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil
+		}
+		return err
+	}
+	fields := f.Value.([]string)
+	if len(fields) < 3 {
+		return errorf(ErrInvalidFile, "object file for %s has %d instead %d fields", *e.Path.Instance, len(fields), 3)
+	}
+	e.Path.App, e.Path.Revision, e.Path.Proc = &fields[0], &fields[1], &fields[2]
+	return nil
+}
+
 func (e *Event) match(filter []EventType) bool {
 	if e.Type == EvUnknown {
 		return false
@@ -252,7 +497,7 @@ func (e *Event) enrich() error {
 		return nil
 	}
 
-	if e.Path.App != nil {
+	if e.Path.App != nil && e.Path.Instance == nil {
 		app, err = getApp(*e.Path.App, e.raw)
 		if err != nil {
 			return err
@@ -266,8 +511,25 @@ func (e *Event) enrich() error {
 		e.Source, err = getRevision(app, *e.Path.Revision, e.raw)
 	case EvProcReg, EvProcAttrs:
 		e.Source, err = getProc(app, *e.Path.Proc, e.raw)
-	case EvInsReg, EvInsUnclaim, EvInsStart, EvInsStop, EvInsFail, EvInsExit, EvInsLost:
-		id, err := strconv.ParseInt(*e.Path.Instance, 10, 64)
+	case EvEnvReg:
+		var env *Env
+		env, err = getEnv(app, *e.Path.Env, e.raw)
+		// Events reach every watcher, the vars of apps with an ACL are only
+		// readable by viewers, see App.GetEnv.
+		if env != nil && app.ACL != nil {
+			env.Vars = nil
+		}
+		e.Source = env
+	case EvTagReg, EvTagUpdate:
+		e.Source, err = getTag(app, *e.Path.Tag, e.raw)
+	case EvHookReg:
+		e.Source, err = getHook(app, *e.Path.Hook, e.raw)
+	case EvRunnerReg:
+		e.Source, err = getRunner(*e.Path.Runner, e.raw)
+	case EvInsReg, EvInsAssign, EvInsClaim, EvInsUnclaim, EvInsStart, EvInsStop,
+		EvInsFail, EvInsExit, EvInsLost, EvInsRestart, EvInsLock:
+		var id int64
+		id, err = strconv.ParseInt(*e.Path.Instance, 10, 64)
 		if err != nil {
 			return err
 		}
@@ -6,6 +6,7 @@
 package visor
 
 import (
+	"encoding/json"
 	"errors"
 	"reflect"
 	"strconv"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
+	"github.com/soundcloud/doozer"
 )
 
 func eventSetup() (*Store, chan *Event) {
@@ -86,7 +88,7 @@ func TestEventAppUnregistered(t *testing.T) {
 
 	go app.WatchEvent(l)
 
-	err = app.Unregister()
+	err = app.Unregister(false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -142,7 +144,7 @@ func TestEventRevUnregistered(t *testing.T) {
 	}
 	go storeFromSnapshotable(rev).WatchEvent(l)
 
-	err = rev.Unregister()
+	err = rev.Unregister(false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -282,6 +284,25 @@ func TestEventInstanceRegistered(t *testing.T) {
 	}
 }
 
+func TestEventInstanceAssigned(t *testing.T) {
+	s, l := eventSetup()
+
+	ins, err := s.RegisterInstance("assignmouse", "stable", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go storeFromSnapshotable(ins).WatchEvent(l, EvInsAssign)
+
+	if _, err := ins.Assign("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := expectEvent(EvInsAssign, ins, l, t)
+	if want, have := "10.0.0.1", ev.Source.(*Instance).Assignee; want != have {
+		t.Errorf("want assignee %s, have %s", want, have)
+	}
+}
+
 func TestEventInstanceUnregistered(t *testing.T) {
 	s, l := eventSetup()
 
@@ -300,6 +321,14 @@ func TestEventInstanceUnregistered(t *testing.T) {
 	if ev.Path.Instance == nil || (*ev.Path.Instance != strconv.FormatInt(ins.ID, 10)) {
 		t.Error("event.Path doesn't contain expected data")
 	}
+	if ev.Path.App == nil || *ev.Path.App != "unregmouse" ||
+		ev.Path.Revision == nil || *ev.Path.Revision != "stable" ||
+		ev.Path.Proc == nil || *ev.Path.Proc != "web" {
+		t.Errorf("expected app, rev and proc of the unregistered instance in the path, got %s", ev.Path)
+	}
+	if !(EventFilter{App: "unregmouse"}).Match(ev) {
+		t.Error("expected app filter to match instance unregister event")
+	}
 }
 
 func TestEventInstanceStateChange(t *testing.T) {
@@ -319,6 +348,7 @@ func TestEventInstanceStateChange(t *testing.T) {
 	if _, err = ins.Claim("0.0.0.0"); err != nil {
 		t.Fatal(err)
 	}
+	expectEvent(EvInsClaim, ins, l, t)
 
 	ins, err = ins.Unclaim("0.0.0.0")
 	if err != nil {
@@ -329,12 +359,16 @@ func TestEventInstanceStateChange(t *testing.T) {
 	if _, err = ins.Claim(ip); err != nil {
 		t.Fatal(err)
 	}
+	ev := expectEvent(EvInsClaim, ins, l, t)
+	if want, have := ip, ev.Source.(*Instance).IP; want != have {
+		t.Errorf("want claimer %s, have %s", want, have)
+	}
 
 	ins, err = ins.Started(ip, host, port, tPort)
 	if err != nil {
 		t.Error(err)
 	}
-	ev := expectEvent(EvInsStart, ins, l, t)
+	ev = expectEvent(EvInsStart, ins, l, t)
 	if ev.Path.Instance == nil || (*ev.Path.Instance != strconv.FormatInt(ins.ID, 10)) {
 		t.Error("event.Path doesn't contain expected data")
 	}
@@ -361,6 +395,175 @@ func TestEventInstanceStateChange(t *testing.T) {
 	expectEvent(EvInsExit, ins, l, t)
 }
 
+func TestEventInstanceRestartAndLock(t *testing.T) {
+	s, l := eventSetup()
+
+	ins, err := s.RegisterInstance("lockmouse", "stable", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go storeFromSnapshotable(ins).WatchEvent(l, EvInsRestart, EvInsLock, EvInsUnlock)
+
+	if _, err := ins.Restarted(InsRestarts{Fail: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvInsRestart, ins, l, t)
+	if want, have := 1, ev.Source.(*Instance).Restarts.Fail; want != have {
+		t.Errorf("want %d restarts, have %d", want, have)
+	}
+
+	ins, err = ins.Lock("visor-test", errors.New("maintenance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvInsLock, ins, l, t)
+
+	if _, err := ins.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	ev = expectEvent(EvInsUnlock, nil, l, t)
+	if ev.Path.Instance == nil || (*ev.Path.Instance != strconv.FormatInt(ins.ID, 10)) {
+		t.Error("event.Path doesn't contain expected data")
+	}
+}
+
+func TestEventEnvRegistered(t *testing.T) {
+	s, l := eventSetup()
+
+	app, err := eventAppSetup(s, "envcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go storeFromSnapshotable(app).WatchEvent(l, EvEnvReg, EvEnvUnreg)
+
+	env, err := app.NewEnv("prod", map[string]string{"A": "1"}).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvEnvReg, env, l, t)
+	if ev.Path.Env == nil || (*ev.Path.Env != env.Ref) {
+		t.Error("event.Path doesn't contain expected data")
+	}
+	if want, have := env.Vars, ev.Source.(*Env).Vars; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %#v, have %#v", want, have)
+	}
+
+	if err := env.Unregister(false); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvEnvUnreg, nil, l, t)
+}
+
+func TestEventEnvRegisteredWithACL(t *testing.T) {
+	s, l := eventSetup()
+
+	app := eventAppSetup(s, "aclcat")
+	app.ACL = &ACL{Owners: []string{"alice"}}
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go storeFromSnapshotable(app).WatchEvent(l, EvEnvReg)
+
+	env, err := app.NewEnv("prod", map[string]string{"A": "1"}).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvEnvReg, env, l, t)
+	if vars := ev.Source.(*Env).Vars; vars != nil {
+		t.Errorf("expected vars of app with ACL to be left out, got %#v", vars)
+	}
+}
+
+func TestEventTagRegisteredAndUpdated(t *testing.T) {
+	s, l := eventSetup()
+
+	app, err := eventAppSetup(s, "tagcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"abc", "def"} {
+		if _, err := s.NewRevision(app, ref, ref+".img").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go s.WatchEvent(l, EvTagReg, EvTagUpdate, EvTagUnreg)
+
+	tag := app.NewTag("stable", "abc")
+	if err := tag.Register(); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvTagReg, tag, l, t)
+	if ev.Path.Tag == nil || (*ev.Path.Tag != tag.Name) {
+		t.Error("event.Path doesn't contain expected data")
+	}
+
+	tag = app.NewTag("stable", "def")
+	if err := tag.Register(); err != nil {
+		t.Fatal(err)
+	}
+	ev = expectEvent(EvTagUpdate, tag, l, t)
+	if want, have := "def", ev.Source.(*Tag).Ref; want != have {
+		t.Errorf("want ref %s, have %s", want, have)
+	}
+
+	if err := tag.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvTagUnreg, nil, l, t)
+}
+
+func TestEventHookRegistered(t *testing.T) {
+	s, l := eventSetup()
+
+	app, err := eventAppSetup(s, "hookcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go storeFromSnapshotable(app).WatchEvent(l, EvHookReg, EvHookUnreg)
+
+	hook, err := app.NewHook("predeploy", "echo").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvHookReg, hook, l, t)
+	if ev.Path.Hook == nil || (*ev.Path.Hook != hook.Name) {
+		t.Error("event.Path doesn't contain expected data")
+	}
+
+	if err := hook.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvHookUnreg, nil, l, t)
+}
+
+func TestEventRunnerRegistered(t *testing.T) {
+	s, l := eventSetup()
+
+	go s.WatchEvent(l, EvRunnerReg, EvRunnerUnreg)
+
+	runner, err := s.NewRunner("10.0.0.1:5000", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, err = runner.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvRunnerReg, runner, l, t)
+	if ev.Path.Runner == nil || (*ev.Path.Runner != runner.Addr) {
+		t.Error("event.Path doesn't contain expected data")
+	}
+	if want, have := int64(42), ev.Source.(*Runner).InstanceID; want != have {
+		t.Errorf("want instance %d, have %d", want, have)
+	}
+
+	if err := runner.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvRunnerUnreg, nil, l, t)
+}
+
 func TestEventInstanceEnrichment(t *testing.T) {
 	s, l := eventSetup()
 
@@ -382,6 +585,122 @@ func TestEventInstanceEnrichment(t *testing.T) {
 	expectEvent(EvInsUnreg, nil, l, t)
 }
 
+func TestEventWatchFrom(t *testing.T) {
+	s, l := eventSetup()
+
+	ins, err := s.RegisterInstance("replaymouse", "stable", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := ins.GetSnapshot().Rev
+
+	ins1, err := s.RegisterInstance("replaymouse", "stable", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.WatchEventFrom(rev, l, EvInsReg)
+
+	ev := expectEvent(EvInsReg, ins1, l, t)
+	if want, have := ins1.ID, ev.Source.(*Instance).ID; want != have {
+		t.Errorf("want instance %d, have %d", want, have)
+	}
+}
+
+func TestEventWatchDone(t *testing.T) {
+	s, l := eventSetup()
+
+	var (
+		done = make(chan struct{})
+		errc = make(chan error, 1)
+	)
+	go func() {
+		errc <- watchEvent(s.GetSnapshot(), l, nil, done)
+	}()
+	if _, err := eventAppSetup(s, "donecat").Register(); err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected watch blocked on the listener to end once done is closed")
+	}
+}
+
+func TestEventWatchFromCompacted(t *testing.T) {
+	s, l := eventSetup()
+
+	// The coordinator answers waits before its first revision like waits on
+	// revisions dropped from its history.
+	if err := s.WatchEventFrom(-1, l); !IsErrRevCompacted(err) {
+		t.Errorf("expected ErrRevCompacted, got %v", err)
+	}
+}
+
+func TestIsErrTooLate(t *testing.T) {
+	tooLate := &doozer.Error{Err: doozer.ErrTooLate, Detail: "rev 1"}
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{doozer.ErrTooLate, true},
+		{tooLate, true},
+		{&cp.Error{Err: tooLate, Detail: "wait"}, true},
+		{&cp.Error{Err: doozer.ErrTooLate, Detail: "wait"}, true},
+		{errors.New("TOO_LATE"), false},
+		{&doozer.Error{Err: errors.New("NOENT")}, false},
+		{&cp.Error{Err: &doozer.Error{Err: errors.New("NOENT")}}, false},
+	} {
+		if have := isErrTooLate(c.err); c.want != have {
+			t.Errorf("%#v: want %t, have %t", c.err, c.want, have)
+		}
+	}
+	if err := revErr(tooLate, 1); !IsErrRevCompacted(err) {
+		t.Errorf("expected ErrRevCompacted, got %v", err)
+	}
+}
+
+func TestEventJSONRoundTrip(t *testing.T) {
+	var (
+		app  = "cat"
+		id   = "42"
+		proc = &Proc{Name: "web", App: &App{Name: app}, Port: 8000}
+		ins  = &Instance{ID: 42, AppName: app, RevisionName: "abc", ProcessName: "web", Status: InsStatusRunning}
+	)
+	proc.Attrs.TrafficControl = &TrafficControl{Share: 30}
+
+	for _, ev := range []*Event{
+		{Type: EvProcAttrs, Rev: 10, Path: EventData{App: &app, Proc: &proc.Name}, Source: proc},
+		{Type: EvInsStart, Rev: 11, Path: EventData{Instance: &id}, Source: ins},
+		{Type: EvInsUnreg, Rev: 12, Path: EventData{Instance: &id}},
+	} {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev1 := &Event{}
+		if err := json.Unmarshal(b, ev1); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != ev1.Type || ev.Rev != ev1.Rev || !reflect.DeepEqual(ev.Path, ev1.Path) {
+			t.Errorf("want %s, have %s", ev, ev1)
+		}
+		if !reflect.DeepEqual(ev.Source, ev1.Source) {
+			t.Errorf("want source %#v, have %#v", ev.Source, ev1.Source)
+		}
+	}
+
+	err := json.Unmarshal([]byte(`{"version":2,"type":"app-register"}`), &Event{})
+	if !IsErrInvalidArgument(err) {
+		t.Errorf("expected unknown version to be rejected, got %v", err)
+	}
+}
+
 func TestEventFilter(t *testing.T) {
 	s, l := eventSetup()
 
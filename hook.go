@@ -42,7 +42,10 @@ func (h *Hook) GetSnapshot() cp.Snapshot {
 
 // Register stores the Hook with the App.
 func (h *Hook) Register() (*Hook, error) {
-	var err error
+	err := authorize(h, h.App.Name, RoleOwner)
+	if err != nil {
+		return nil, err
+	}
 
 	h.Registered = time.Now()
 
@@ -51,11 +54,17 @@ func (h *Hook) Register() (*Hook, error) {
 		return nil, err
 	}
 
+	if err := audit(h, h.file.Path, AuditRegister, nil, nil); err != nil {
+		return nil, err
+	}
 	return h, nil
 }
 
 // Unregister removes the stored Hook from the App.
 func (h *Hook) Unregister() error {
+	if err := authorize(h, h.App.Name, RoleOwner); err != nil {
+		return err
+	}
 	sp, err := h.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -67,7 +76,10 @@ func (h *Hook) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `hook "%s" not found`, h.Name)
 	}
-	return h.file.Del()
+	if err := h.file.Del(); err != nil {
+		return err
+	}
+	return audit(h, h.file.Path, AuditUnregister, nil, nil)
 }
 
 // GetHook retrieves the Hook for the passed name.
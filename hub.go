@@ -0,0 +1,288 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"strconv"
+	"sync"
+)
+
+// OverflowPolicy decides what happens to a Subscription whose buffer is full.
+type OverflowPolicy int
+
+// OverflowPolicies.
+const (
+	// OverflowBlock holds back delivery to all subscribers until there is
+	// room in the buffer.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered Event.
+	OverflowDropOldest
+	// OverflowDisconnect closes the Subscription with ErrSlowSubscriber.
+	OverflowDisconnect
+)
+
+// EventFilter selects Events for a Subscription. Empty fields match
+// everything.
+type EventFilter struct {
+	Types    []EventType
+	App      string
+	Proc     string
+	Rev      string
+	Instance int64
+}
+
+// Match checks if the Event passes the filter. App, proc and rev are taken
+// from the event path, which holds them for instance events too, or else from
+// the Instance the event refers to.
+func (f EventFilter) Match(e *Event) bool {
+	if !e.match(f.Types) {
+		return false
+	}
+	ins, _ := e.Source.(*Instance)
+
+	if f.App != "" {
+		app := stringOrEmpty(e.Path.App)
+		if app == "" && ins != nil {
+			app = ins.AppName
+		}
+		if app != f.App {
+			return false
+		}
+	}
+	if f.Proc != "" {
+		proc := stringOrEmpty(e.Path.Proc)
+		if proc == "" && ins != nil {
+			proc = ins.ProcessName
+		}
+		if proc != f.Proc {
+			return false
+		}
+	}
+	if f.Rev != "" {
+		rev := stringOrEmpty(e.Path.Revision)
+		if rev == "" && ins != nil {
+			rev = ins.RevisionName
+		}
+		if rev != f.Rev {
+			return false
+		}
+	}
+	if f.Instance != 0 {
+		id, _ := strconv.ParseInt(stringOrEmpty(e.Path.Instance), 10, 64)
+		if id != f.Instance {
+			return false
+		}
+	}
+	return true
+}
+
+// EventHub watches the coordinator once and fans the Events out to any number
+// of Subscriptions.
+type EventHub struct {
+	store *Store
+	mu    sync.Mutex
+	subs  map[*Subscription]bool
+	err   error
+}
+
+// NewEventHub returns an EventHub watching from the Store's revision.
+func (s *Store) NewEventHub() *EventHub {
+	return &EventHub{
+		store: s,
+		subs:  map[*Subscription]bool{},
+	}
+}
+
+// Subscribe registers a Subscription receiving the Events matching f over a
+// channel buffered by size. Subscriptions which don't block are buffered by
+// at least one Event.
+func (h *EventHub) Subscribe(f EventFilter, size int, policy OverflowPolicy) *Subscription {
+	if policy != OverflowBlock && size < 1 {
+		size = 1
+	}
+	ch := make(chan *Event, size)
+	sub := &Subscription{
+		C:      ch,
+		hub:    h,
+		filter: f,
+		policy: policy,
+		ch:     ch,
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.err != nil {
+		sub.close(h.err)
+		return sub
+	}
+	h.subs[sub] = true
+
+	return sub
+}
+
+// Run watches the coordinator and dispatches Events until the watch fails.
+// All Subscriptions are closed with the error of the watch.
+func (h *EventHub) Run() error {
+	ch := make(chan *Event)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.store.WatchEvent(ch)
+	}()
+
+	for {
+		select {
+		case e := <-ch:
+			h.dispatch(e)
+		case err := <-errc:
+			h.mu.Lock()
+			h.err = err
+			subs := h.subs
+			h.subs = map[*Subscription]bool{}
+			h.mu.Unlock()
+
+			for sub := range subs {
+				sub.close(err)
+			}
+			return err
+		}
+	}
+}
+
+func (h *EventHub) dispatch(e *Event) {
+	h.mu.Lock()
+	subs := make([]*Subscription, 0, len(h.subs))
+	for sub := range h.subs {
+		subs = append(subs, sub)
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		if err := sub.deliver(e); err != nil {
+			h.remove(sub)
+			sub.close(err)
+		}
+	}
+}
+
+func (h *EventHub) remove(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// Subscription receives the Events of an EventHub matching its filter.
+type Subscription struct {
+	// C receives the Events, it's closed once the Subscription ends.
+	C <-chan *Event
+
+	hub    *EventHub
+	filter EventFilter
+	policy OverflowPolicy
+	ch     chan *Event
+	done   chan struct{}
+	once   sync.Once
+
+	sendMu  sync.Mutex
+	mu      sync.Mutex
+	closed  bool
+	err     error
+	dropped int
+}
+
+// Close ends the Subscription.
+func (sub *Subscription) Close() {
+	sub.hub.remove(sub)
+	sub.close(nil)
+}
+
+// Err returns the reason the Subscription was closed by the EventHub, nil if
+// it was closed by the subscriber or is still open.
+func (sub *Subscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.err
+}
+
+// Dropped returns the number of Events discarded by OverflowDropOldest.
+func (sub *Subscription) Dropped() int {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.dropped
+}
+
+func (sub *Subscription) deliver(e *Event) error {
+	// sendMu keeps close from closing the channel during a send, mu is only
+	// held briefly, so Err and Dropped don't stall while delivery blocks.
+	sub.sendMu.Lock()
+	defer sub.sendMu.Unlock()
+
+	sub.mu.Lock()
+	closed := sub.closed
+	sub.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	switch sub.policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case sub.ch <- e:
+				return nil
+			default:
+			}
+			select {
+			case <-sub.ch:
+				sub.mu.Lock()
+				sub.dropped++
+				sub.mu.Unlock()
+			default:
+			}
+		}
+	case OverflowDisconnect:
+		select {
+		case sub.ch <- e:
+			return nil
+		default:
+			return errorf(ErrSlowSubscriber, "subscriber buffer of %d events overflowed", cap(sub.ch))
+		}
+	default:
+		// close signals done before taking sendMu, which unblocks us.
+		select {
+		case sub.ch <- e:
+		case <-sub.done:
+		}
+		return nil
+	}
+}
+
+func (sub *Subscription) close(err error) {
+	sub.once.Do(func() { close(sub.done) })
+
+	sub.sendMu.Lock()
+	defer sub.sendMu.Unlock()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	sub.err = err
+	close(sub.ch)
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func hubEvent(t EventType, app string) *Event {
+	return &Event{Type: t, Path: EventData{App: &app}}
+}
+
+func TestEventFilterMatch(t *testing.T) {
+	var (
+		ins = &Instance{ID: 42, AppName: "cat", ProcessName: "web", RevisionName: "abc"}
+		id  = "42"
+		ev  = &Event{Type: EvInsStart, Path: EventData{Instance: &id}, Source: ins}
+	)
+
+	for _, c := range []struct {
+		filter EventFilter
+		match  bool
+	}{
+		{EventFilter{}, true},
+		{EventFilter{Types: []EventType{EvInsStart}}, true},
+		{EventFilter{Types: []EventType{EvInsStop}}, false},
+		{EventFilter{App: "cat", Proc: "web", Rev: "abc"}, true},
+		{EventFilter{App: "dog"}, false},
+		{EventFilter{Proc: "worker"}, false},
+		{EventFilter{Instance: 42}, true},
+		{EventFilter{Instance: 43}, false},
+	} {
+		if want, have := c.match, c.filter.Match(ev); want != have {
+			t.Errorf("%#v: want match %t, have %t", c.filter, want, have)
+		}
+	}
+
+	if !(EventFilter{App: "cat"}).Match(hubEvent(EvAppReg, "cat")) {
+		t.Error("expected app filter to match event path")
+	}
+
+	// Unregister events have no source, the path holds app, proc and rev.
+	var (
+		app, proc, rev = "cat", "web", "abc"
+		unreg          = &Event{Type: EvInsUnreg, Path: EventData{Instance: &id, App: &app, Proc: &proc, Revision: &rev}}
+	)
+	if !(EventFilter{App: "cat", Proc: "web", Rev: "abc"}).Match(unreg) {
+		t.Error("expected app filter to match instance unregister event")
+	}
+	if (EventFilter{App: "dog"}).Match(unreg) {
+		t.Error("expected app filter not to match instance of another app")
+	}
+}
+
+func TestEventHubOverflow(t *testing.T) {
+	h := (&Store{}).NewEventHub()
+
+	dropping := h.Subscribe(EventFilter{}, 1, OverflowDropOldest)
+	disconnecting := h.Subscribe(EventFilter{}, 1, OverflowDisconnect)
+	filtered := h.Subscribe(EventFilter{App: "dog"}, 1, OverflowDisconnect)
+
+	h.dispatch(hubEvent(EvAppReg, "cat"))
+	h.dispatch(hubEvent(EvAppUnreg, "cat"))
+
+	if want, have := EvAppUnreg, (<-dropping.C).Type; want != have {
+		t.Errorf("want newest event %s, have %s", want, have)
+	}
+	if want, have := 1, dropping.Dropped(); want != have {
+		t.Errorf("want %d dropped events, have %d", want, have)
+	}
+
+	if want, have := EvAppReg, (<-disconnecting.C).Type; want != have {
+		t.Errorf("want event %s, have %s", want, have)
+	}
+	if _, ok := <-disconnecting.C; ok {
+		t.Error("expected overflowing subscription to be closed")
+	}
+	if !IsErrSlowSubscriber(disconnecting.Err()) {
+		t.Errorf("expected ErrSlowSubscriber, got %v", disconnecting.Err())
+	}
+
+	if filtered.Err() != nil {
+		t.Errorf("expected filtered subscription to stay open, got %v", filtered.Err())
+	}
+	filtered.Close()
+	if _, ok := <-filtered.C; ok {
+		t.Error("expected closed subscription")
+	}
+}
+
+func TestEventHubBlockingClose(t *testing.T) {
+	h := (&Store{}).NewEventHub()
+	sub := h.Subscribe(EventFilter{}, 0, OverflowBlock)
+
+	done := make(chan struct{})
+	go func() {
+		h.dispatch(hubEvent(EvAppReg, "cat"))
+		close(done)
+	}()
+
+	select {
+	case e := <-sub.C:
+		if e.Type != EvAppReg {
+			t.Errorf("unexpected event %s", e.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected event")
+	}
+	<-done
+
+	// Nobody reads, Close has to unblock the dispatcher.
+	blocked := make(chan struct{})
+	go func() {
+		h.dispatch(hubEvent(EvAppUnreg, "cat"))
+		close(blocked)
+	}()
+	sub.Close()
+
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to unblock dispatch")
+	}
+}
+
+func TestEventHubBlockingAccessors(t *testing.T) {
+	h := (&Store{}).NewEventHub()
+	sub := h.Subscribe(EventFilter{}, 0, OverflowBlock)
+
+	blocked := make(chan struct{})
+	go func() {
+		h.dispatch(hubEvent(EvAppReg, "cat"))
+		close(blocked)
+	}()
+
+	// Nobody reads, the dispatcher blocks without stalling the accessors.
+	accessed := make(chan struct{})
+	go func() {
+		sub.Err()
+		sub.Dropped()
+		close(accessed)
+	}()
+	select {
+	case <-accessed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Err and Dropped not to block during delivery")
+	}
+
+	sub.Close()
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to unblock dispatch")
+	}
+}
+
+func TestEventHubRun(t *testing.T) {
+	s, _ := eventSetup()
+	h := s.NewEventHub()
+
+	cats := h.Subscribe(EventFilter{App: "hubcat"}, 10, OverflowBlock)
+	dogs := h.Subscribe(EventFilter{App: "hubdog", Types: []EventType{EvAppReg}}, 10, OverflowBlock)
+	go h.Run()
+
+	if _, err := eventAppSetup(s, "hubcat").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eventAppSetup(s, "hubdog").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		sub *Subscription
+		app string
+	}{
+		{cats, "hubcat"},
+		{dogs, "hubdog"},
+	} {
+		select {
+		case e := <-c.sub.C:
+			if e.Type != EvAppReg || *e.Path.App != c.app {
+				t.Errorf("unexpected event %s for %s", e.Type, c.app)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected event for %s", c.app)
+		}
+	}
+}
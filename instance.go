@@ -7,6 +7,7 @@ package visor
 
 import (
 	"fmt"
+	"net"
 	"path"
 	"sort"
 	"strconv"
@@ -16,6 +17,7 @@ import (
 )
 
 const (
+	assignPath    = "assign"
 	claimsPath    = "claims"
 	instancesPath = "instances"
 	donePath      = "done"
@@ -71,6 +73,7 @@ type Termination struct {
 // Instance represents service instances.
 type Instance struct {
 	dir          *cp.Dir
+	identity     identity
 	ID           int64       `json:"id"`
 	AppName      string      `json:"app"`
 	RevisionName string      `json:"rev"`
@@ -84,6 +87,7 @@ type Instance struct {
 	Restarts     InsRestarts `json:"restarts"`
 	Registered   time.Time   `json:"registered"`
 	Claimed      time.Time   `json:"claimed"`
+	Assignee     string      `json:"assignee,omitempty"`
 	Termination  Termination `json:"termination,omitempty"`
 }
 
@@ -98,7 +102,7 @@ func (s *Store) GetInstance(id int64) (ins *Instance, err error) {
 	if err != nil {
 		return
 	}
-	return getInstance(id, sp)
+	return getInstance(id, s.join(sp))
 }
 
 // GetSerialisedInstance returns an instance for the given id and status.
@@ -151,6 +155,9 @@ func (s *Store) RegisterInstance(app, rev, proc, env string) (ins *Instance, err
 	//   apps/<app>/procs/<proc>/instances/<rev>
 	// +     6868 = 2012-07-19 16:41 UTC
 	//
+	if err = authorize(s, app, RoleDeployer); err != nil {
+		return
+	}
 	id, err := s.GetSnapshot().Getuid()
 	if err != nil {
 		return
@@ -164,6 +171,7 @@ func (s *Store) RegisterInstance(app, rev, proc, env string) (ins *Instance, err
 		Registered:   time.Now(),
 		Status:       InsStatusPending,
 		dir:          cp.NewDir(instancePath(id), s.GetSnapshot()),
+		identity:     s.identity,
 	}
 
 	object := cp.NewFile(ins.dir.Prefix(objectPath), ins.objectArray(), new(cp.ListCodec), s.GetSnapshot())
@@ -192,20 +200,46 @@ func (s *Store) RegisterInstance(app, rev, proc, env string) (ins *Instance, err
 
 	ins.dir = ins.dir.Join(registered)
 
+	err = audit(ins, ins.dir.Name, AuditRegister, nil, ins.objectArray())
+
 	return
 }
 
 // Unregister removes the instance tree representation.
 func (i *Instance) Unregister(client string, reason error) error {
+	if err := authorize(i, i.AppName, RoleDeployer); err != nil {
+		return err
+	}
+	status := i.Status
 	i, err := i.updateLookup(i.Status, InsStatusDone, client, reason)
 	if err != nil {
 		return err
 	}
-	return i.dir.Del("/")
+	// The registered file goes first, so the object file is still there at
+	// the revision before the unregister event, see Event.setInstancePath.
+	if err := i.dir.Del(registeredPath); err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	if err := i.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	return audit(i, i.dir.Name, AuditUnregister, string(status), nil)
 }
 
 // Claim locks the instance to the specified host.
 func (i *Instance) Claim(host string) (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
+	claimName, err := encodeHost(host)
+	if err != nil {
+		return nil, err
+	}
+
 	done, err := i.IsDone()
 	if err != nil {
 		return nil, err
@@ -214,6 +248,21 @@ func (i *Instance) Claim(host string) (*Instance, error) {
 		return nil, errorf(ErrUnauthorized, "%s is done", i)
 	}
 
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	cordoned, err := isPmCordoned(host, sp)
+	if err != nil {
+		return nil, err
+	}
+	if cordoned {
+		return nil, errorf(ErrUnauthorized, "pm %s is cordoned", host)
+	}
+	if err := checkPmDeployType(host, i.AppName, sp); err != nil {
+		return nil, err
+	}
+
 	//
 	//   instances/
 	//       6868/
@@ -233,6 +282,16 @@ func (i *Instance) Claim(host string) (*Instance, error) {
 	}
 	d := i.dir.Join(f)
 
+	// The assignee is read at the rev the claim is conditional on.
+	assignee, _, err := d.Get(assignPath)
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	if assignee != "" && assignee != host {
+		return nil, errorf(ErrUnauthorized, "%s is assigned to %s", i, assignee)
+	}
+
+	read := d.Snapshot.Rev
 	d, err = d.Set(startPath, host)
 	if err != nil {
 		if cp.IsErrRevMismatch(err) {
@@ -240,15 +299,127 @@ func (i *Instance) Claim(host string) (*Instance, error) {
 		}
 		return i, err
 	}
+	// Assign doesn't touch the start file, so an assignment to another host
+	// made after the read isn't caught by the write. The claim is released
+	// again in that case, assignments made after the write are undone by
+	// Assign.
+	assignee, err = assignedSince(d.Snapshot, i.dir.Prefix(assignPath), read)
+	if err != nil {
+		return nil, err
+	}
+	if assignee != "" && assignee != host {
+		if _, err := d.Set(startPath, ""); err != nil {
+			return nil, err
+		}
+		return nil, errorf(ErrUnauthorized, "%s is assigned to %s", i, assignee)
+	}
 
 	claimed := time.Now()
-	d, err = i.claimDir().Join(d).Set(host, formatTime(claimed))
+	d, err = i.claimDir().Join(d).Set(claimName, formatTime(claimed))
 	if err != nil {
 		return nil, err
 	}
 	i.Claimed = claimed
 	i.dir = i.dir.Join(d)
-	return i, err
+
+	if err := audit(i, i.dir.Name, AuditClaim, nil, host); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Assign reserves the pending Instance for the given host. Once assigned, only
+// that host is allowed to claim the Instance. Assigning an already assigned
+// Instance moves the reservation to the new host.
+func (i *Instance) Assign(host string) (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
+	//
+	//   instances/
+	//       6868/
+	//           object = <app> <rev> <proc>
+	//           start  =
+	// +         assign = 10.0.0.1
+	//
+	claimer, err := i.getClaimer()
+	if err != nil {
+		return nil, err
+	}
+	if claimer != nil {
+		return nil, errorf(ErrInsClaimed, "%s already claimed", i)
+	}
+
+	prev, _, err := i.dir.Get(assignPath)
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	d, err := i.dir.Set(assignPath, host)
+	if err != nil {
+		return nil, err
+	}
+	// A claim made by another host before the assignment wins, Claim only
+	// gives way to assignments made before its own write. The previous
+	// assignment is restored, so neither side is left failing.
+	f, err := d.GetFile(startPath, new(cp.ListCodec))
+	if err != nil {
+		return nil, err
+	}
+	if fields := f.Value.([]string); len(fields) > 0 && fields[0] != host {
+		if prev == "" {
+			err = d.Del(assignPath)
+		} else {
+			_, err = d.Set(assignPath, prev)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, errorf(ErrInsClaimed, "%s already claimed", i)
+	}
+	i.Assignee = host
+	i.dir = d
+
+	if err := audit(i, i.dir.Name, AuditAssign, prev, host); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Unassign removes the reservation made by Assign, any host is allowed to
+// claim the Instance afterwards.
+func (i *Instance) Unassign() (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
+	//
+	//   instances/
+	//       6868/
+	//           object = <app> <rev> <proc>
+	//           start  =
+	// -         assign = 10.0.0.1
+	//
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	err = sp.Del(i.dir.Prefix(assignPath))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	sp, err = sp.FastForward()
+	if err != nil {
+		return nil, err
+	}
+	prev := i.Assignee
+	i.Assignee = ""
+	i.dir = i.dir.Join(sp)
+
+	if err := audit(i, i.dir.Name, AuditAssign, prev, nil); err != nil {
+		return nil, err
+	}
+
+	return i, nil
 }
 
 // Claims returns the list of claimers.
@@ -257,7 +428,7 @@ func (i *Instance) Claims() (claims []string, err error) {
 	if err != nil {
 		return
 	}
-	claims, err = sp.Getdir(i.dir.Prefix("claims"))
+	claims, err = getHosts(sp, i.dir.Prefix(claimsPath))
 	if cp.IsErrNoEnt(err) {
 		claims = []string{}
 		err = nil
@@ -267,6 +438,9 @@ func (i *Instance) Claims() (claims []string, err error) {
 
 // Unclaim removes the lock applied by Claim of the Ticket.
 func (i *Instance) Unclaim(host string) (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
 	//
 	//   instances/
 	//       6868/
@@ -284,11 +458,18 @@ func (i *Instance) Unclaim(host string) (*Instance, error) {
 	}
 	i.dir = d
 
+	if err := audit(i, i.dir.Name, AuditUnclaim, host, nil); err != nil {
+		return nil, err
+	}
+
 	return i, nil
 }
 
 // Started puts the Instance into start state.
 func (i *Instance) Started(host, hostname string, port, telePort int) (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
 	//
 	//   instances/
 	//       6868/
@@ -303,6 +484,7 @@ func (i *Instance) Started(host, hostname string, port, telePort int) (*Instance
 	if err != nil {
 		return nil, err
 	}
+	status := i.Status
 	i.started(host, hostname, port, telePort)
 
 	start := cp.NewFile(i.dir.Prefix(startPath), i.startArray(), new(cp.ListCodec), i.GetSnapshot())
@@ -312,11 +494,18 @@ func (i *Instance) Started(host, hostname string, port, telePort int) (*Instance
 	}
 	i.dir = i.dir.Join(start)
 
+	if err := audit(i, i.dir.Name, AuditStart, string(status), string(i.Status)); err != nil {
+		return nil, err
+	}
+
 	return i, nil
 }
 
 // Restarted tells the coordinator that the instance has been restarted.
 func (i *Instance) Restarted(restarts InsRestarts) (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
 	//
 	//   instances/
 	//       6868/
@@ -336,7 +525,7 @@ func (i *Instance) Restarted(restarts InsRestarts) (*Instance, error) {
 		return i, err
 	}
 
-	i, err = getInstance(i.ID, sp)
+	i, err = getInstance(i.ID, storeFromSnapshotable(i).join(sp))
 	if err != nil {
 		return nil, err
 	}
@@ -352,9 +541,14 @@ func (i *Instance) Restarted(restarts InsRestarts) (*Instance, error) {
 		return nil, err
 	}
 
+	prev := i.Restarts
 	i.Restarts = restarts
 	i.dir = i.dir.Join(f)
 
+	if err := audit(i, i.dir.Name, AuditRestart, prev, restarts); err != nil {
+		return nil, err
+	}
+
 	return i, nil
 }
 
@@ -366,12 +560,15 @@ func (i *Instance) Stop() error {
 	//           ...
 	// +         stop =
 	//
+	if err := authorize(i, i.AppName, RoleDeployer); err != nil {
+		return err
+	}
 	sp, err := i.GetSnapshot().FastForward()
 	if err != nil {
 		return err
 	}
 
-	i, err = getInstance(i.ID, sp)
+	i, err = getInstance(i.ID, storeFromSnapshotable(i).join(sp))
 	if err != nil {
 		return err
 	}
@@ -384,7 +581,7 @@ func (i *Instance) Stop() error {
 		return err
 	}
 
-	return nil
+	return audit(i, i.dir.Name, AuditStop, nil, nil)
 }
 
 // Failed transitions the instance to failed.
@@ -393,6 +590,9 @@ func (i *Instance) Stop() error {
 // It returns a revision mismatch error if the status is pending, but another
 // caller has already failed this instance.
 func (i *Instance) Failed(host string, reason error) (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
 	status := i.Status
 
 	if status != InsStatusPending {
@@ -404,31 +604,56 @@ func (i *Instance) Failed(host string, reason error) (*Instance, error) {
 	if _, err := i.updateStatus(InsStatusFailed); err != nil {
 		return nil, err
 	}
-	return i.updateLookup(status, InsStatusFailed, host, reason)
+	i, err := i.updateLookup(status, InsStatusFailed, host, reason)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit(i, i.dir.Name, AuditFail, string(status), string(InsStatusFailed)); err != nil {
+		return nil, err
+	}
+	return i, nil
 }
 
 // Lost transitions the instance into lost state and updates the
 // coordinator with client and reason.
 func (i *Instance) Lost(client string, reason error) (*Instance, error) {
+	if err := authorizeLifecycle(i, i.AppName); err != nil {
+		return nil, err
+	}
 	current := i.Status
 
 	_, err := i.updateStatus(InsStatusLost)
 	if err != nil {
 		return nil, err
 	}
-	return i.updateLookup(current, InsStatusLost, client, reason)
+	i, err = i.updateLookup(current, InsStatusLost, client, reason)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit(i, i.dir.Name, AuditLose, string(current), string(InsStatusLost)); err != nil {
+		return nil, err
+	}
+	return i, nil
 }
 
 // Exited tells the coordinator that the instance has exited.
 func (i *Instance) Exited(host string) (i1 *Instance, err error) {
+	if err = authorizeLifecycle(i, i.AppName); err != nil {
+		return
+	}
 	if err = i.verifyClaimer(host); err != nil {
 		return
 	}
+	status := i.Status
 	i1, err = i.updateStatus(InsStatusExited)
 	if err != nil {
 		return nil, err
 	}
 	err = i.dir.Snapshot.Del(i.procStatusPath(InsStatusExited))
+	if err != nil {
+		return
+	}
+	err = audit(i1, i1.dir.Name, AuditExit, string(status), string(InsStatusExited))
 
 	return
 }
@@ -545,6 +770,9 @@ func (i *Instance) GetStatusInfo() (string, error) {
 
 // Lock sets the lock path to the given client and reason.
 func (i *Instance) Lock(client string, reason error) (*Instance, error) {
+	if err := authorize(i, i.AppName, RoleDeployer); err != nil {
+		return nil, err
+	}
 	locked, err := i.IsLocked()
 	if err != nil {
 		return nil, err
@@ -553,20 +781,30 @@ func (i *Instance) Lock(client string, reason error) (*Instance, error) {
 		return nil, errorf(ErrUnauthorized, "instance %d is already locked", i.ID)
 	}
 
-	i.dir, err = i.dir.Set(lockPath, fmt.Sprintf("%s %s %s", timestamp(), client, reason))
+	lock := fmt.Sprintf("%s %s %s", timestamp(), client, reason)
+	i.dir, err = i.dir.Set(lockPath, lock)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := audit(i, i.dir.Name, AuditLock, nil, lock); err != nil {
+		return nil, err
+	}
 	return i, nil
 }
 
 // Unlock removes the instance lock path.
 func (i *Instance) Unlock() (*Instance, error) {
+	if err := authorize(i, i.AppName, RoleDeployer); err != nil {
+		return nil, err
+	}
 	err := i.dir.Del(lockPath)
 	if err != nil {
 		return nil, err
 	}
+	if err := audit(i, i.dir.Name, AuditUnlock, nil, nil); err != nil {
+		return nil, err
+	}
 	return i, nil
 }
 
@@ -636,7 +874,7 @@ func (i *Instance) Fields() string {
 
 // String returns the Go-syntax representation of Instance.
 func (i *Instance) String() string {
-	return fmt.Sprintf("Instance{id=%d, app=%s, rev=%s, proc=%s, env=%s, addr=%s:%d}", i.ID, i.AppName, i.RevisionName, i.ProcessName, i.Env, i.IP, i.Port)
+	return fmt.Sprintf("Instance{id=%d, app=%s, rev=%s, proc=%s, env=%s, addr=%s}", i.ID, i.AppName, i.RevisionName, i.ProcessName, i.Env, net.JoinHostPort(i.IP, i.portString()))
 }
 
 // IDString returns a string of the format "INSTANCE[id]"
@@ -644,10 +882,6 @@ func (i *Instance) IDString() string {
 	return fmt.Sprintf("INSTANCE[%d]", i.ID)
 }
 
-func (i *Instance) claimPath(host string) string {
-	return i.dir.Prefix("claims", host)
-}
-
 func (i *Instance) claimDir() *cp.Dir {
 	return cp.NewDir(i.dir.Prefix(claimsPath), i.GetSnapshot())
 }
@@ -746,6 +980,20 @@ func (i *Instance) getClaimer() (*string, error) {
 	return &fields[0], nil
 }
 
+// assignedSince returns the host stored at the assign file p if the file has
+// been written after rev.
+func assignedSince(sp cp.Snapshot, p string, rev int64) (string, error) {
+	exists, fileRev, err := sp.Exists(p)
+	if err != nil || !exists || fileRev <= rev {
+		return "", err
+	}
+	assignee, _, err := sp.Get(p)
+	if cp.IsErrNoEnt(err) {
+		return "", nil
+	}
+	return assignee, err
+}
+
 func (i *Instance) setClaimer(claimer string) (*cp.Dir, error) {
 	d, err := i.dir.Set(startPath, claimer)
 	if err != nil {
@@ -877,6 +1125,11 @@ func (s *Store) GetInstances() ([]*Instance, error) {
 	if err != nil {
 		return nil, err
 	}
+	return getInstances(s.join(sp))
+}
+
+func getInstances(s cp.Snapshotable) ([]*Instance, error) {
+	sp := s.GetSnapshot()
 	ids, err := sp.Getdir(instancesPath)
 	if err != nil {
 		return nil, err
@@ -888,7 +1141,7 @@ func (s *Store) GetInstances() ([]*Instance, error) {
 		if err != nil {
 			return nil, err
 		}
-		return getInstance(id, sp)
+		return getInstance(id, s)
 	})
 	errStr := ""
 	for i := 0; i < len(ids); i++ {
@@ -953,9 +1206,10 @@ func parseInstanceID(idstr string) (int64, error) {
 
 func getInstance(id int64, s cp.Snapshotable) (*Instance, error) {
 	i := &Instance{
-		ID:     id,
-		Status: InsStatusPending,
-		dir:    cp.NewDir(instancePath(id), s.GetSnapshot()),
+		ID:       id,
+		Status:   InsStatusPending,
+		dir:      cp.NewDir(instancePath(id), s.GetSnapshot()),
+		identity: identityOf(s),
 	}
 
 	exists, _, err := s.GetSnapshot().Exists(i.dir.Name)
@@ -1034,6 +1288,13 @@ func getInstance(id int64, s cp.Snapshotable) (*Instance, error) {
 		return nil, err
 	}
 
+	assignee, _, err := i.dir.Get(assignPath)
+	if err == nil {
+		i.Assignee = string(assignee)
+	} else if !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
 	f, err = i.dir.GetFile(registeredPath, new(cp.StringCodec))
 	if err != nil {
 		return nil, err
@@ -1043,7 +1304,10 @@ func getInstance(id int64, s cp.Snapshotable) (*Instance, error) {
 		return nil, err
 	}
 
-	f, err = i.claimDir().GetFile(i.IP, new(cp.StringCodec))
+	if i.IP == "" {
+		return i, nil
+	}
+	f, err = i.claimDir().GetFile(hostName(i.IP), new(cp.StringCodec))
 	if err != nil {
 		if cp.IsErrNoEnt(err) {
 			return i, nil
@@ -209,6 +209,62 @@ func TestInstanceClaiming(t *testing.T) {
 	}
 }
 
+func TestInstanceAssignClaimRace(t *testing.T) {
+	hostA := "10.0.0.1"
+	hostB := "10.0.0.2"
+	s := instanceSetup()
+
+	for n := 0; n < 20; n++ {
+		ins, err := s.RegisterInstance("bat", "128af9", "web", "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claimer, err := s.GetInstance(ins.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var (
+			claimErr  = make(chan error)
+			assignErr = make(chan error)
+		)
+		go func() {
+			_, err := claimer.Claim(hostA)
+			claimErr <- err
+		}()
+		go func() {
+			_, err := ins.Assign(hostB)
+			assignErr <- err
+		}()
+		cerr, aerr := <-claimErr, <-assignErr
+
+		ins, err = s.GetInstance(ins.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case cerr == nil && aerr == nil:
+			t.Fatalf("expected either the claim or the assignment to fail")
+		case cerr == nil:
+			if !IsErrInsClaimed(aerr) {
+				t.Errorf("expected assignment to fail with ErrInsClaimed, got %v", aerr)
+			}
+			if ins.Assignee == hostB {
+				t.Errorf("expected failed assignment to be undone")
+			}
+		case aerr == nil:
+			if !IsErrUnauthorized(cerr) {
+				t.Errorf("expected claim to fail with ErrUnauthorized, got %v", cerr)
+			}
+			if ins.Status != InsStatusPending || ins.Assignee != hostB {
+				t.Errorf("expected pending instance assigned to %s, got %s assigned to %q", hostB, ins.Status, ins.Assignee)
+			}
+		default:
+			t.Fatalf("expected one side to win, claim: %v, assign: %v", cerr, aerr)
+		}
+	}
+}
+
 func TestInstanceStarted(t *testing.T) {
 	app := "fat"
 	rev := "128af9"
@@ -0,0 +1,231 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// Logger is the representation of a bazooka-log process.
+type Logger struct {
+	dir        *cp.Dir
+	Addr       string
+	Version    string
+	Meta       map[string]string
+	Registered time.Time
+	Heartbeat  time.Time
+	// Stale is set if the last heartbeat is older than HeartbeatTTL.
+	Stale bool
+}
+
+// NewLogger returns a new Logger given an address in the form host:port and a
+// version. It returns ErrInvalidArgument for malformed addresses.
+func (s *Store) NewLogger(addr, version string) (*Logger, error) {
+	name, err := encodeAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{
+		dir:     cp.NewDir(path.Join(loggerDir, name), s.GetSnapshot()),
+		Addr:    addr,
+		Version: version,
+		Meta:    map[string]string{},
+	}, nil
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (l *Logger) GetSnapshot() cp.Snapshot {
+	return l.dir.Snapshot
+}
+
+// Register stores the Logger, registering an existing Logger updates it.
+func (l *Logger) Register() (*Logger, error) {
+	v := serviceAttrs{Version: l.Version, Meta: l.Meta}
+	d, reg, err := registerService(l.dir, v)
+	if err != nil {
+		return nil, err
+	}
+	l.Registered = reg
+	l.Heartbeat = reg
+	l.Stale = false
+	l.dir = d
+
+	return l, nil
+}
+
+// SendHeartbeat marks the Logger as alive.
+func (l *Logger) SendHeartbeat() (*Logger, error) {
+	d, now, err := heartbeatService(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	l.Heartbeat = now
+	l.Stale = false
+	l.dir = d
+
+	return l, nil
+}
+
+// Unregister removes the Logger from the store.
+func (l *Logger) Unregister() error {
+	sp, err := l.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	return l.dir.Join(sp).Del("/")
+}
+
+func (l *Logger) String() string {
+	return fmt.Sprintf("Logger<%s>{version: %s}", l.Addr, l.Version)
+}
+
+// RegisterLogger given an address and a version stores the Logger.
+func (s *Store) RegisterLogger(addr, version string) (*Store, error) {
+	l, err := s.NewLogger(addr, version)
+	if err != nil {
+		return nil, err
+	}
+	l, err = l.Register()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = l.GetSnapshot()
+	return s, nil
+}
+
+// UnregisterLogger removes the logger for the given address from the store.
+func (s *Store) UnregisterLogger(addr string) error {
+	l, err := s.NewLogger(addr, "")
+	if err != nil {
+		return err
+	}
+	return l.Unregister()
+}
+
+// GetLoggers gets the list of bazooka-log services endpoints.
+func (s *Store) GetLoggers() ([]string, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	names, err := sp.Getdir(loggerDir)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		names[i], err = decodeAddr(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// GetLogger fetches the Logger for the given address.
+func (s *Store) GetLogger(addr string) (*Logger, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	name, err := encodeAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return getLogger(name, sp)
+}
+
+// ListLoggers returns all registered Loggers, the ones without a recent
+// heartbeat are flagged as Stale.
+func (s *Store) ListLoggers() ([]*Logger, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	names, err := sp.Getdir(loggerDir)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = nil
+		}
+		return []*Logger{}, err
+	}
+
+	loggers := []*Logger{}
+	ch, errch := cp.GetSnapshotables(names, func(name string) (cp.Snapshotable, error) {
+		return getLogger(name, sp)
+	})
+	for i := 0; i < len(names); i++ {
+		select {
+		case l := <-ch:
+			loggers = append(loggers, l.(*Logger))
+		case err := <-errch:
+			return nil, err
+		}
+	}
+	return loggers, nil
+}
+
+// WatchLoggers sends every registered Logger over reg and the address of every
+// unregistered Logger over unreg. Either channel can be nil.
+func (s *Store) WatchLoggers(reg chan *Logger, unreg chan string, errch chan error) {
+	errch <- watchServices(s, loggerDir, func(name string, ev cp.Event) error {
+		if reg == nil {
+			return nil
+		}
+		l, err := getLogger(name, ev)
+		if err != nil {
+			return err
+		}
+		reg <- l
+		return nil
+	}, func(name string) error {
+		if unreg == nil {
+			return nil
+		}
+		addr, err := decodeAddr(name)
+		if err != nil {
+			return err
+		}
+		unreg <- addr
+		return nil
+	})
+}
+
+func getLogger(name string, s cp.Snapshotable) (*Logger, error) {
+	addr, err := decodeAddr(name)
+	if err != nil {
+		return nil, err
+	}
+	l := &Logger{
+		dir:  cp.NewDir(path.Join(loggerDir, name), s.GetSnapshot()),
+		Addr: addr,
+		Meta: map[string]string{},
+	}
+
+	attrs := &serviceAttrs{}
+	_, err = l.dir.GetFile(serviceAttrsPath, &cp.JsonCodec{DecodedVal: attrs})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, `logger "%s" not found`, l.Addr)
+		}
+		return nil, err
+	}
+	l.Version = attrs.Version
+	if attrs.Meta != nil {
+		l.Meta = attrs.Meta
+	}
+
+	l.Registered, l.Heartbeat, err = getServiceTimes(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	l.Stale = isStale(l.Heartbeat)
+
+	return l, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func loggerSetup() *Store {
+	s, err := DialURI(DefaultURI, "/logger-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestLoggerRegisterAndGet(t *testing.T) {
+	s := loggerSetup()
+	addr := "log-1.example.com:9000"
+
+	s, err := s.RegisterLogger(addr, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := s.GetLogger(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := addr, l.Addr; want != have {
+		t.Errorf("want addr %s, have %s", want, have)
+	}
+	if want, have := "v1", l.Version; want != have {
+		t.Errorf("want version %s, have %s", want, have)
+	}
+
+	addrs, err := s.GetLoggers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != addr {
+		t.Errorf("unexpected loggers: %v", addrs)
+	}
+
+	loggers, err := s.ListLoggers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loggers) != 1 || loggers[0].Addr != addr {
+		t.Errorf("unexpected loggers: %v", loggers)
+	}
+
+	if err := s.UnregisterLogger(addr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetLogger(addr); !IsErrNotFound(err) {
+		t.Errorf("expected logger to be unregistered, got %v", err)
+	}
+}
+
+func TestLoggerInvalidAddr(t *testing.T) {
+	s := loggerSetup()
+
+	if _, err := s.RegisterLogger("10.0.0.1", "v1"); err == nil {
+		t.Error("expected address without port to be rejected")
+	}
+}
+
+func TestWatchLoggers(t *testing.T) {
+	var (
+		s     = loggerSetup()
+		reg   = make(chan *Logger)
+		errch = make(chan error)
+		addr  = "10.0.0.1:9000"
+	)
+
+	go s.WatchLoggers(reg, nil, errch)
+
+	if _, err := s.RegisterLogger(addr, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case l := <-reg:
+		if l.Addr != addr {
+			t.Errorf("received unexpected logger: %s", l)
+		}
+	case err := <-errch:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("expected logger, got timeout")
+	}
+}
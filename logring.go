@@ -0,0 +1,238 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// LoggerRingReplicas is the number of points each Logger occupies on the
+// LoggerRing. More points spread Instances more evenly.
+var LoggerRingReplicas = 128
+
+// LoggerRing maps keys to Logger addresses with consistent hashing, so only
+// the keys of a joining or leaving Logger move.
+type LoggerRing struct {
+	hashes []uint32
+	addrs  map[uint32]string
+}
+
+// NewLoggerRing returns a LoggerRing for the given Logger addresses.
+func NewLoggerRing(addrs []string) *LoggerRing {
+	r := &LoggerRing{
+		hashes: []uint32{},
+		addrs:  map[uint32]string{},
+	}
+	for _, addr := range addrs {
+		for i := 0; i < LoggerRingReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "-" + addr))
+			if _, ok := r.addrs[h]; ok {
+				continue
+			}
+			r.addrs[h] = addr
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Sort(hashes(r.hashes))
+	return r
+}
+
+// Get returns the Logger address for key or an empty string if the ring is
+// empty.
+func (r *LoggerRing) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.addrs[r.hashes[i]]
+}
+
+// LoggerReassignment is sent when the Logger of an Instance changes. From is
+// empty for Instances which had no Logger, To is empty for Instances which
+// lost their Logger.
+type LoggerReassignment struct {
+	InstanceID int64
+	From       string
+	To         string
+}
+
+// GetInstanceLogger returns the address of the Logger the Instance ships its
+// logs to, honouring the LogRouting of its proc.
+func (s *Store) GetInstanceLogger(ins *Instance) (string, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return "", err
+	}
+	s = storeFromSnapshotable(sp)
+
+	loggers, err := s.GetLoggers()
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return "", err
+	}
+	routing, err := s.getLogRouting(ins.AppName, ins.ProcessName)
+	if err != nil {
+		return "", err
+	}
+
+	addr := assignLogger(ins, loggers, routing)
+	if addr == "" {
+		return "", errorf(ErrNotFound, "no logger for %s", ins)
+	}
+	return addr, nil
+}
+
+// WatchLoggerAssignments sends a LoggerReassignment over ch for every claimed
+// or running Instance whose Logger changes as Loggers register or unregister.
+func (s *Store) WatchLoggerAssignments(ch chan *LoggerReassignment, errch chan error) {
+	s, err := s.FastForward()
+	if err != nil {
+		errch <- err
+		return
+	}
+	current, err := s.GetLoggers()
+	if err != nil && !cp.IsErrNoEnt(err) {
+		errch <- err
+		return
+	}
+
+	var (
+		reg   = make(chan *Logger)
+		unreg = make(chan string)
+		werr  = make(chan error, 1)
+	)
+	go s.WatchLoggers(reg, unreg, werr)
+
+	for {
+		next := []string{}
+		select {
+		case l := <-reg:
+			if containsString(current, l.Addr) {
+				continue
+			}
+			next = append(next, current...)
+			next = append(next, l.Addr)
+			s = storeFromSnapshotable(l)
+		case addr := <-unreg:
+			if !containsString(current, addr) {
+				continue
+			}
+			for _, a := range current {
+				if a != addr {
+					next = append(next, a)
+				}
+			}
+			s, err = s.FastForward()
+			if err != nil {
+				errch <- err
+				return
+			}
+		case err := <-werr:
+			errch <- err
+			return
+		}
+
+		reassignments, err := s.getLoggerReassignments(current, next)
+		if err != nil {
+			errch <- err
+			return
+		}
+		for _, r := range reassignments {
+			ch <- r
+		}
+		current = next
+	}
+}
+
+func (s *Store) getLoggerReassignments(from, to []string) ([]*LoggerReassignment, error) {
+	instances, err := s.GetInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		reassignments = []*LoggerReassignment{}
+		routings      = map[string]*LogRouting{}
+	)
+	for _, ins := range instances {
+		if ins.Status != InsStatusClaimed && ins.Status != InsStatusRunning {
+			continue
+		}
+
+		key := ins.AppName + ":" + ins.ProcessName
+		routing, ok := routings[key]
+		if !ok {
+			routing, err = s.getLogRouting(ins.AppName, ins.ProcessName)
+			if err != nil {
+				return nil, err
+			}
+			routings[key] = routing
+		}
+
+		r := &LoggerReassignment{
+			InstanceID: ins.ID,
+			From:       assignLogger(ins, from, routing),
+			To:         assignLogger(ins, to, routing),
+		}
+		if r.From != r.To {
+			reassignments = append(reassignments, r)
+		}
+	}
+	return reassignments, nil
+}
+
+// getLogRouting returns the LogRouting of the given proc, Instances of
+// unknown procs fall back to the default routing.
+func (s *Store) getLogRouting(app, proc string) (*LogRouting, error) {
+	a, err := getApp(app, s)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p, err := getProc(a, proc, s)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return p.Attrs.LogRouting, nil
+}
+
+// assignLogger picks the Logger for the Instance from loggers, restricted by
+// the given LogRouting.
+func assignLogger(ins *Instance, loggers []string, routing *LogRouting) string {
+	if routing != nil {
+		if routing.Disabled {
+			return ""
+		}
+		if len(routing.Loggers) > 0 {
+			allowed := []string{}
+			for _, addr := range loggers {
+				if containsString(routing.Loggers, addr) {
+					allowed = append(allowed, addr)
+				}
+			}
+			loggers = allowed
+		}
+	}
+	return NewLoggerRing(loggers).Get(strconv.FormatInt(ins.ID, 10))
+}
+
+type hashes []uint32
+
+func (h hashes) Len() int           { return len(h) }
+func (h hashes) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h hashes) Less(i, j int) bool { return h[i] < h[j] }
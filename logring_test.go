@@ -0,0 +1,108 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"strconv"
+	"testing"
+)
+
+func logRingSetup() *Store {
+	s, err := DialURI(DefaultURI, "/logring-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestLoggerRingStable(t *testing.T) {
+	var (
+		before = NewLoggerRing([]string{"10.0.0.1:8000", "10.0.0.2:8000", "10.0.0.3:8000"})
+		after  = NewLoggerRing([]string{"10.0.0.1:8000", "10.0.0.2:8000", "10.0.0.3:8000", "10.0.0.4:8000"})
+		keys   = 1000
+		moved  = 0
+	)
+	for i := 0; i < keys; i++ {
+		key := strconv.Itoa(i)
+		from, to := before.Get(key), after.Get(key)
+		if from != to {
+			if to != "10.0.0.4:8000" {
+				t.Fatalf("key %s moved from %s to %s instead of the new logger", key, from, to)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > keys/2 {
+		t.Errorf("expected a small share of keys to move, %d of %d moved", moved, keys)
+	}
+
+	if have := NewLoggerRing(nil).Get("1"); have != "" {
+		t.Errorf("expected empty ring to return no logger, got %s", have)
+	}
+}
+
+func TestGetInstanceLogger(t *testing.T) {
+	s := logRingSetup()
+
+	for _, addr := range []string{"10.0.0.1:8000", "10.0.0.2:8000"} {
+		if _, err := s.RegisterLogger(addr, "v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := s.GetInstanceLogger(ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "10.0.0.1:8000" && addr != "10.0.0.2:8000" {
+		t.Errorf("unexpected logger %s", addr)
+	}
+
+	proc.Attrs.LogRouting = &LogRouting{Loggers: []string{"10.0.0.2:8000"}}
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	addr, err = s.GetInstanceLogger(ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "10.0.0.2:8000", addr; want != have {
+		t.Errorf("want logger %s, have %s", want, have)
+	}
+
+	proc.Attrs.LogRouting = &LogRouting{Disabled: true}
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetInstanceLogger(ins)
+	if !IsErrNotFound(err) {
+		t.Errorf("expected ErrNotFound for disabled log shipping, got %v", err)
+	}
+}
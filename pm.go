@@ -0,0 +1,382 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const cordonPath = "cordon"
+
+// PmCapacity describes the resources a pm offers to instances. Zero values
+// mean unlimited.
+type PmCapacity struct {
+	MemoryMb     int `json:"memory-mb"`
+	CPUs         int `json:"cpus"`
+	MaxInstances int `json:"max-instances"`
+}
+
+// Pm is the representation of a bazooka-pm process.
+type Pm struct {
+	dir      *cp.Dir
+	Host     string
+	Version  string
+	Capacity PmCapacity
+	Labels   map[string]string
+	Meta     map[string]string
+	// DeployTypes lists the deploy types the Pm is able to run, pms
+	// registered without any run lxc.
+	DeployTypes []string
+	Cordoned    bool
+	Registered  time.Time
+	Heartbeat   time.Time
+	// Stale is set if the last heartbeat is older than HeartbeatTTL.
+	Stale bool
+}
+
+type pmAttrs struct {
+	serviceAttrs
+	Capacity    PmCapacity        `json:"capacity"`
+	Labels      map[string]string `json:"labels"`
+	DeployTypes []string          `json:"deploy-types,omitempty"`
+}
+
+// DrainProgress reports the handling of a single Instance while draining a
+// pm. Replacement is nil for Instances which were only claimed and therefore
+// released for other pms to claim.
+type DrainProgress struct {
+	Instance    *Instance
+	Replacement *Instance
+	Done        int
+	Total       int
+	Err         error
+}
+
+// NewPm returns a new Pm given a host and version.
+func (s *Store) NewPm(host, version string) *Pm {
+	return &Pm{
+		dir:     cp.NewDir(hostPath(pmDir, host), s.GetSnapshot()),
+		Host:    host,
+		Version: version,
+		Labels:  map[string]string{},
+		Meta:    map[string]string{},
+
+		DeployTypes: []string{DeployLXC},
+	}
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (p *Pm) GetSnapshot() cp.Snapshot {
+	return p.dir.Snapshot
+}
+
+// Register stores the Pm with its capacity and labels. Registering an
+// existing Pm updates it, a set cordon is kept.
+func (p *Pm) Register() (*Pm, error) {
+	//
+	//   pms/
+	//       10.0.0.1/
+	// +         attrs      = {"version":"v1","capacity":{...},"labels":{...}}
+	// +         heartbeat  = 2012-07-19 16:41 UTC
+	// +         registered = 2012-07-19 16:41 UTC
+	//
+	if _, err := encodeHost(p.Host); err != nil {
+		return nil, err
+	}
+	for _, t := range p.DeployTypes {
+		if _, err := GetDeployType(t); err != nil {
+			return nil, err
+		}
+	}
+
+	v := pmAttrs{
+		serviceAttrs: serviceAttrs{Version: p.Version, Meta: p.Meta},
+		Capacity:     p.Capacity,
+		Labels:       p.Labels,
+		DeployTypes:  p.DeployTypes,
+	}
+	d, reg, err := registerService(p.dir, v)
+	if err != nil {
+		return nil, err
+	}
+	p.Registered = reg
+	p.Heartbeat = reg
+	p.Stale = false
+	p.dir = d
+
+	return p, nil
+}
+
+// SendHeartbeat marks the Pm as alive.
+func (p *Pm) SendHeartbeat() (*Pm, error) {
+	d, now, err := heartbeatService(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	p.Heartbeat = now
+	p.Stale = false
+	p.dir = d
+
+	return p, nil
+}
+
+// Unregister removes the Pm from the store. The pending Instances assigned
+// to it are unassigned, so other pms may claim them.
+func (p *Pm) Unregister() error {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	instances, err := getInstances(storeFromSnapshotable(p).join(sp))
+	if err != nil && !cp.IsErrNoEnt(err) && !IsErrNotFound(err) {
+		return err
+	}
+	for _, ins := range instances {
+		if ins.Status != InsStatusPending || ins.Assignee != p.Host {
+			continue
+		}
+		if _, err := ins.Unassign(); err != nil {
+			return err
+		}
+	}
+	sp, err = sp.FastForward()
+	if err != nil {
+		return err
+	}
+	return p.dir.Join(sp).Del("/")
+}
+
+// Cordon marks the Pm as unschedulable, claims from its host are refused
+// until Uncordon is called.
+func (p *Pm) Cordon() (*Pm, error) {
+	d, err := p.dir.Set(cordonPath, timestamp())
+	if err != nil {
+		return nil, err
+	}
+	p.Cordoned = true
+	p.dir = d
+
+	return p, nil
+}
+
+// Uncordon allows the Pm to claim instances again.
+func (p *Pm) Uncordon() (*Pm, error) {
+	err := p.dir.Del(cordonPath)
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	p.Cordoned = false
+	p.dir = p.dir.Join(sp)
+
+	return p, nil
+}
+
+// Drain cordons the Pm and moves all of its Instances elsewhere. Running
+// Instances are replaced by newly registered ones before they are stopped,
+// claimed Instances are unclaimed. Progress for every Instance is sent over the
+// given channel if it is not nil. Drain continues on failures and returns the
+// first error encountered.
+func (p *Pm) Drain(progress chan *DrainProgress) error {
+	p, err := p.Cordon()
+	if err != nil {
+		return err
+	}
+
+	instances, err := p.GetInstances()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for n, ins := range instances {
+		var replacement *Instance
+
+		switch ins.Status {
+		case InsStatusRunning:
+			replacement, err = storeFromSnapshotable(ins).RegisterInstance(ins.AppName, ins.RevisionName, ins.ProcessName, ins.Env)
+			if err == nil {
+				err = ins.Stop()
+			}
+		case InsStatusClaimed:
+			_, err = ins.Unclaim(p.Host)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("draining %s: %s", ins, err)
+		}
+
+		if progress != nil {
+			progress <- &DrainProgress{
+				Instance:    ins,
+				Replacement: replacement,
+				Done:        n + 1,
+				Total:       len(instances),
+				Err:         err,
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// GetInstances returns all claimed or running Instances of the Pm.
+func (p *Pm) GetInstances() ([]*Instance, error) {
+	instances, err := storeFromSnapshotable(p).GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+
+	result := []*Instance{}
+	for _, ins := range instances {
+		if ins.IP != p.Host {
+			continue
+		}
+		if ins.Status == InsStatusClaimed || ins.Status == InsStatusRunning {
+			result = append(result, ins)
+		}
+	}
+	return result, nil
+}
+
+func (p *Pm) String() string {
+	return fmt.Sprintf("Pm<%s>{version: %s}", p.Host, p.Version)
+}
+
+// RegisterPm stores the pm for the given host.
+func (s *Store) RegisterPm(host, version string) (*Store, error) {
+	p, err := s.NewPm(host, version).Register()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = p.GetSnapshot()
+	return s, nil
+}
+
+// UnregisterPm removes the pm for the given host.
+func (s *Store) UnregisterPm(host string) error {
+	return s.NewPm(host, "").Unregister()
+}
+
+// GetPms gets the list of bazooka-pm service IPs
+func (s *Store) GetPms() ([]string, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getHosts(sp, pmDir)
+}
+
+// GetPm fetches the Pm for the given host.
+func (s *Store) GetPm(host string) (*Pm, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getPm(host, sp)
+}
+
+// ListPms returns all registered Pms, the ones without a recent heartbeat are
+// flagged as Stale.
+func (s *Store) ListPms() ([]*Pm, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := getHosts(sp, pmDir)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = nil
+		}
+		return []*Pm{}, err
+	}
+
+	pms := []*Pm{}
+	ch, errch := cp.GetSnapshotables(hosts, func(host string) (cp.Snapshotable, error) {
+		return getPm(host, sp)
+	})
+	for i := 0; i < len(hosts); i++ {
+		select {
+		case p := <-ch:
+			pms = append(pms, p.(*Pm))
+		case err := <-errch:
+			return nil, err
+		}
+	}
+	return pms, nil
+}
+
+// WatchPms sends every registered Pm over reg and the host of every
+// unregistered Pm over unreg. Either channel can be nil.
+func (s *Store) WatchPms(reg chan *Pm, unreg chan string, errch chan error) {
+	errch <- watchServices(s, pmDir, func(name string, ev cp.Event) error {
+		if reg == nil {
+			return nil
+		}
+		p, err := getPm(decodeHost(name), ev)
+		if err != nil {
+			return err
+		}
+		reg <- p
+		return nil
+	}, func(name string) error {
+		if unreg != nil {
+			unreg <- decodeHost(name)
+		}
+		return nil
+	})
+}
+
+func getPm(host string, s cp.Snapshotable) (*Pm, error) {
+	p := storeFromSnapshotable(s).NewPm(host, "")
+
+	attrs := &pmAttrs{}
+	_, err := p.dir.GetFile(serviceAttrsPath, &cp.JsonCodec{DecodedVal: attrs})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, `pm "%s" not found`, host)
+		}
+		return nil, err
+	}
+	p.Version = attrs.Version
+	p.Capacity = attrs.Capacity
+	if attrs.Labels != nil {
+		p.Labels = attrs.Labels
+	}
+	if attrs.Meta != nil {
+		p.Meta = attrs.Meta
+	}
+	if len(attrs.DeployTypes) > 0 {
+		p.DeployTypes = attrs.DeployTypes
+	}
+
+	p.Registered, p.Heartbeat, err = getServiceTimes(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	p.Stale = isStale(p.Heartbeat)
+
+	p.Cordoned, err = isPmCordoned(host, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func isPmCordoned(host string, s cp.Snapshotable) (bool, error) {
+	exists, _, err := s.GetSnapshot().Exists(path.Join(hostPath(pmDir, host), cordonPath))
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
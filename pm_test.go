@@ -0,0 +1,290 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+func pmSetup() *Store {
+	s, err := DialURI(DefaultURI, "/pm-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestPmRegisterAndGet(t *testing.T) {
+	s := pmSetup()
+
+	pm := s.NewPm("10.0.0.1", "v1")
+	pm.Capacity = PmCapacity{MemoryMb: 4096, CPUs: 4, MaxInstances: 10}
+	pm.Labels["zone"] = "a"
+
+	pm, err := pm.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm1, err := s.GetPm("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := pm.Version, pm1.Version; want != have {
+		t.Errorf("want version %s, have %s", want, have)
+	}
+	if want, have := pm.Capacity, pm1.Capacity; want != have {
+		t.Errorf("want capacity %#v, have %#v", want, have)
+	}
+	if want, have := pm.Labels, pm1.Labels; !reflect.DeepEqual(want, have) {
+		t.Errorf("want labels %#v, have %#v", want, have)
+	}
+
+	hosts, err := s.GetPms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0] != "10.0.0.1" {
+		t.Errorf("unexpected pms: %v", hosts)
+	}
+
+	if err := pm1.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPm("10.0.0.1"); !IsErrNotFound(err) {
+		t.Errorf("expected pm to be unregistered, got %v", err)
+	}
+}
+
+func TestPmCordon(t *testing.T) {
+	s := pmSetup()
+
+	pm, err := s.NewPm("10.0.0.1", "v1").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err = pm.Cordon()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim(pm.Host); !IsErrUnauthorized(err) {
+		t.Errorf("expected claim on cordoned pm to fail, got %v", err)
+	}
+
+	// Cordon survives re-registration.
+	pm, err = s.NewPm("10.0.0.1", "v2").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err = s.GetPm(pm.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pm.Cordoned {
+		t.Error("expected pm to stay cordoned")
+	}
+
+	pm, err = pm.Uncordon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim(pm.Host); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPmDrain(t *testing.T) {
+	s := pmSetup()
+	host := "10.0.0.1"
+
+	pm, err := s.NewPm(host, "v1").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err = running.Claim(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err = running.Started(host, "box1.cat.net", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := s.RegisterInstance("cat", "128af9", "worker", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = claimed.Claim(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress := make(chan *DrainProgress, 2)
+	if err := pm.Drain(progress); err != nil {
+		t.Fatal(err)
+	}
+	close(progress)
+
+	reports := map[int64]*DrainProgress{}
+	for p := range progress {
+		if p.Err != nil {
+			t.Error(p.Err)
+		}
+		if p.Total != 2 {
+			t.Errorf("want total 2, have %d", p.Total)
+		}
+		reports[p.Instance.ID] = p
+	}
+
+	if r, ok := reports[running.ID]; !ok || r.Replacement == nil {
+		t.Errorf("expected running instance to be replaced")
+	} else if r.Replacement.ProcessName != "web" {
+		t.Errorf("unexpected replacement %s", r.Replacement)
+	}
+	if r, ok := reports[claimed.ID]; !ok || r.Replacement != nil {
+		t.Errorf("expected claimed instance to be unclaimed")
+	}
+
+	ins, err := s.GetInstance(running.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusStopping {
+		t.Errorf("expected instance to be stopping, is %s", ins.Status)
+	}
+	ins, err = s.GetInstance(claimed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusPending {
+		t.Errorf("expected instance to be pending, is %s", ins.Status)
+	}
+}
+
+func TestMigrateServices(t *testing.T) {
+	// Layout of schema 7.
+	s := migrateSetup(t, 7, map[string]string{
+		pmDir + "/10.0.0.1":          "2013-01-02T03:04:05Z v1",
+		proxyDir + "/10.0.0.2":       "2013-01-02T03:04:05Z",
+		loggerDir + "/10.0.0.3-5000": "2013-01-02T03:04:05Z v2",
+	})
+
+	pms, err := s.ListPms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pms) != 1 || pms[0].Host != "10.0.0.1" || pms[0].Version != "v1" || !pms[0].Stale {
+		t.Errorf("unexpected migrated pms %v", pms)
+	}
+	if want, have := "2013-01-02T03:04:05Z", formatTime(pms[0].Registered); want != have {
+		t.Errorf("want registered %s, have %s", want, have)
+	}
+	proxies, err := s.ListProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 1 || proxies[0].Host != "10.0.0.2" {
+		t.Errorf("unexpected migrated proxies %v", proxies)
+	}
+	loggers, err := s.ListLoggers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loggers) != 1 || loggers[0].Addr != "10.0.0.3:5000" || loggers[0].Version != "v2" {
+		t.Errorf("unexpected migrated loggers %v", loggers)
+	}
+}
+
+func TestMigrateServicesSchema8(t *testing.T) {
+	// Layout of schema 8, pms are directories already.
+	s := migrateSetup(t, 8, map[string]string{
+		pmDir + "/10.0.0.1/attrs":      `{"version":"v1","capacity":{"memory-mb":2048},"labels":{"zone":"a"}}`,
+		pmDir + "/10.0.0.1/registered": "2013-01-02T03:04:05Z",
+		proxyDir + "/10.0.0.2":         "2013-01-02T03:04:05Z",
+		loggerDir + "/10.0.0.3-5000":   "2013-01-02T03:04:05Z v2",
+	})
+
+	pm, err := s.GetPm("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pm.Version != "v1" || pm.Capacity.MemoryMb != 2048 || pm.Labels["zone"] != "a" {
+		t.Errorf("unexpected pm %#v", pm)
+	}
+	if want, have := "2013-01-02T03:04:05Z", formatTime(pm.Heartbeat); want != have {
+		t.Errorf("want heartbeat %s, have %s", want, have)
+	}
+	proxies, err := s.ListProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 1 || proxies[0].Host != "10.0.0.2" {
+		t.Errorf("unexpected migrated proxies %v", proxies)
+	}
+	loggers, err := s.ListLoggers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loggers) != 1 || loggers[0].Addr != "10.0.0.3:5000" || loggers[0].Version != "v2" {
+		t.Errorf("unexpected migrated loggers %v", loggers)
+	}
+}
+
+func migrateSetup(t *testing.T, version int, files map[string]string) *Store {
+	s := pmSetup()
+
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, v := range files {
+		sp, err = sp.Set(p, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cp.SetSchemaVersion(version, sp); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifySchema(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
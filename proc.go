@@ -6,6 +6,7 @@
 package visor
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -19,21 +20,39 @@ var reProcName = regexp.MustCompile("^[[:alnum:]]+$")
 // Proc represents a process type with a certain scale.
 type Proc struct {
 	dir         *cp.Dir
-	Name        string
-	App         *App
-	Port        int
-	ControlPort int
-	Attrs       ProcAttrs
-	Registered  time.Time
+	Name        string    `json:"name"`
+	App         *App      `json:"-"`
+	Port        int       `json:"port"`
+	ControlPort int       `json:"controlPort"`
+	Attrs       ProcAttrs `json:"attrs"`
+	Registered  time.Time `json:"registered"`
 }
 
 // ProcAttrs are mutable extra information for a proc.
 type ProcAttrs struct {
 	Limits         ResourceLimits  `json:"limits"`
-	LogPersistence bool            `json:"log_persistence"`
+	LogRouting     *LogRouting     `json:"logRouting,omitempty"`
 	TrafficControl *TrafficControl `json:"trafficControl"`
 }
 
+// UnmarshalJSON decodes ProcAttrs and translates the deprecated
+// log_persistence flag into a LogRouting.
+func (a *ProcAttrs) UnmarshalJSON(b []byte) error {
+	type attrs ProcAttrs
+	v := struct {
+		*attrs
+		LogPersistence *bool `json:"log_persistence"`
+	}{attrs: (*attrs)(a)}
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if a.LogRouting == nil && v.LogPersistence != nil && *v.LogPersistence {
+		a.LogRouting = &LogRouting{Persist: true}
+	}
+	return nil
+}
+
 // ResourceLimits are per proc constraints like memory/cpu.
 type ResourceLimits struct {
 	// Maximum memory allowance in MB for an instance of this Proc.
@@ -45,6 +64,27 @@ type TrafficControl struct {
 	Share int `json:"share"`
 }
 
+// LogRouting describes where the logs of a proc's instances are shipped to.
+// Procs without LogRouting ship to any registered Logger without persisting.
+type LogRouting struct {
+	// Disabled turns off log shipping for the proc.
+	Disabled bool `json:"disabled"`
+	// Persist stores the logs beyond the logger's buffer.
+	Persist bool `json:"persist"`
+	// Loggers restricts the Loggers to pick from, empty means all.
+	Loggers []string `json:"loggers,omitempty"`
+}
+
+// Validate checks if the configured Loggers are valid addresses.
+func (r *LogRouting) Validate() error {
+	for _, addr := range r.Loggers {
+		if _, err := encodeAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Validate checks if the configured traffic share is in the allowed
 // boundaries.
 func (t *TrafficControl) Validate() error {
@@ -78,6 +118,9 @@ func (p *Proc) GetSnapshot() cp.Snapshot {
 
 // Register registers a proc with the registry.
 func (p *Proc) Register() (*Proc, error) {
+	if err := authorize(p, p.App.Name, RoleOwner); err != nil {
+		return nil, err
+	}
 	sp, err := p.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -130,16 +173,25 @@ func (p *Proc) Register() (*Proc, error) {
 	p.Registered = reg
 	p.dir = d
 
+	if err := audit(p, p.dir.Name, AuditRegister, nil, nil); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
 // Unregister unregisters a proc from the registry.
 func (p *Proc) Unregister() error {
+	if err := authorize(p, p.App.Name, RoleOwner); err != nil {
+		return err
+	}
 	sp, err := p.GetSnapshot().FastForward()
 	if err != nil {
 		return err
 	}
-	return p.dir.Join(sp).Del("/")
+	if err := p.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	return audit(p, p.dir.Name, AuditUnregister, nil, nil)
 }
 
 // DoneInstancesPath returns the doozerd path where done instances are stored.
@@ -228,6 +280,10 @@ func (p *Proc) GetInstances() ([]*Instance, error) {
 	if err != nil {
 		return nil, err
 	}
+	return p.getInstances(sp)
+}
+
+func (p *Proc) getInstances(sp cp.Snapshot) ([]*Instance, error) {
 	ids, err := getProcInstanceIds(p, sp)
 	if err != nil {
 		return nil, err
@@ -237,7 +293,7 @@ func (p *Proc) GetInstances() ([]*Instance, error) {
 		s := strconv.FormatInt(id, 10)
 		idStrs = append(idStrs, s)
 	}
-	return getProcInstances(idStrs, sp)
+	return getProcInstances(idStrs, storeFromSnapshotable(p).join(sp))
 }
 
 // GetRunningRevs returns all revs with at least one running instance.
@@ -253,25 +309,49 @@ func (p Proc) GetRunningRevs() ([]string, error) {
 	return revs, nil
 }
 
-// StoreAttrs saves the set Attrs for the Proc.
+// StoreAttrs saves the set Attrs for the Proc. It fails with ErrStaleWrite if
+// they have been changed since the Proc was read, see UpdateAttrs.
 func (p *Proc) StoreAttrs() (*Proc, error) {
+	if err := authorize(p, p.App.Name, RoleDeployer); err != nil {
+		return nil, err
+	}
 	if p.Attrs.TrafficControl != nil {
 		if err := p.Attrs.TrafficControl.Validate(); err != nil {
 			return nil, err
 		}
 	}
+	if p.Attrs.LogRouting != nil {
+		if err := p.Attrs.LogRouting.Validate(); err != nil {
+			return nil, err
+		}
+	}
 
 	sp, err := p.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
 	}
+	what := fmt.Sprintf("attrs of %s", p)
+	if err := checkUnmodified(what, p.dir.Prefix(procsAttrsPath), p.GetSnapshot(), sp); err != nil {
+		return nil, err
+	}
+	var old interface{}
+	f, err := sp.GetFile(p.dir.Prefix(procsAttrsPath), new(cp.JsonCodec))
+	if err == nil {
+		old = f.Value
+	} else if !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
 	attrs := cp.NewFile(p.dir.Prefix(procsAttrsPath), p.Attrs, new(cp.JsonCodec), sp)
 	attrs, err = attrs.Save()
 	if err != nil {
-		return nil, err
+		return nil, staleErr(what, err)
 	}
 	p.dir = p.dir.Join(attrs)
 
+	if err := audit(p, p.dir.Name, AuditSetAttrs, old, p.Attrs); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
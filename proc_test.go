@@ -6,6 +6,7 @@
 package visor
 
 import (
+	"encoding/json"
 	"errors"
 	"reflect"
 	"testing"
@@ -325,11 +326,12 @@ func TestProcAttr(t *testing.T) {
 		t.Fatalf("MemoryLimitMb does not contain the value that was set")
 	}
 
-	// LogPersistence
-	if proc.Attrs.LogPersistence != false {
-		t.Fatal("LogPersistence should be off by default")
+	// LogRouting
+	if proc.Attrs.LogRouting != nil {
+		t.Fatalf("want %#v, have %#v", nil, proc.Attrs.LogRouting)
 	}
-	proc.Attrs.LogPersistence = true
+	logRouting := &LogRouting{Persist: true, Loggers: []string{"10.0.0.1:8000"}}
+	proc.Attrs.LogRouting = logRouting
 	if _, err := proc.StoreAttrs(); err != nil {
 		t.Fatal(err)
 	}
@@ -337,10 +339,16 @@ func TestProcAttr(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if proc.Attrs.LogPersistence != true {
-		t.Fatalf("LogPersistence should be on after change")
+	if !reflect.DeepEqual(logRouting, proc.Attrs.LogRouting) {
+		t.Fatalf("want %#v, have %#v", logRouting, proc.Attrs.LogRouting)
 	}
 
+	proc.Attrs.LogRouting = &LogRouting{Loggers: []string{"10.0.0.1"}}
+	if _, err := proc.StoreAttrs(); !IsErrInvalidArgument(err) {
+		t.Fatalf("expected invalid logger address to be rejected, got %v", err)
+	}
+	proc.Attrs.LogRouting = logRouting
+
 	// TrafficControl
 	if proc.Attrs.TrafficControl != nil {
 		t.Fatalf("want %#v, have %#v", nil, proc.Attrs.TrafficControl)
@@ -381,3 +389,21 @@ func TestTrafficControlValidate(t *testing.T) {
 		t.Error("expected TrafficControl to not validate")
 	}
 }
+
+func TestProcAttrsLogPersistenceCompat(t *testing.T) {
+	attrs := ProcAttrs{}
+	if err := json.Unmarshal([]byte(`{"log_persistence":true}`), &attrs); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (&LogRouting{Persist: true}), attrs.LogRouting; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %#v, have %#v", want, have)
+	}
+
+	attrs = ProcAttrs{}
+	if err := json.Unmarshal([]byte(`{"log_persistence":false}`), &attrs); err != nil {
+		t.Fatal(err)
+	}
+	if attrs.LogRouting != nil {
+		t.Errorf("want %#v, have %#v", nil, attrs.LogRouting)
+	}
+}
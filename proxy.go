@@ -0,0 +1,194 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// Proxy is the representation of a bazooka-proxy process.
+type Proxy struct {
+	dir        *cp.Dir
+	Host       string
+	Version    string
+	Meta       map[string]string
+	Registered time.Time
+	Heartbeat  time.Time
+	// Stale is set if the last heartbeat is older than HeartbeatTTL.
+	Stale bool
+}
+
+// NewProxy returns a new Proxy given a host and version.
+func (s *Store) NewProxy(host, version string) *Proxy {
+	return &Proxy{
+		dir:     cp.NewDir(hostPath(proxyDir, host), s.GetSnapshot()),
+		Host:    host,
+		Version: version,
+		Meta:    map[string]string{},
+	}
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (p *Proxy) GetSnapshot() cp.Snapshot {
+	return p.dir.Snapshot
+}
+
+// Register stores the Proxy, registering an existing Proxy updates it.
+func (p *Proxy) Register() (*Proxy, error) {
+	if _, err := encodeHost(p.Host); err != nil {
+		return nil, err
+	}
+
+	v := serviceAttrs{Version: p.Version, Meta: p.Meta}
+	d, reg, err := registerService(p.dir, v)
+	if err != nil {
+		return nil, err
+	}
+	p.Registered = reg
+	p.Heartbeat = reg
+	p.Stale = false
+	p.dir = d
+
+	return p, nil
+}
+
+// SendHeartbeat marks the Proxy as alive.
+func (p *Proxy) SendHeartbeat() (*Proxy, error) {
+	d, now, err := heartbeatService(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	p.Heartbeat = now
+	p.Stale = false
+	p.dir = d
+
+	return p, nil
+}
+
+// Unregister removes the Proxy from the store.
+func (p *Proxy) Unregister() error {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	return p.dir.Join(sp).Del("/")
+}
+
+func (p *Proxy) String() string {
+	return fmt.Sprintf("Proxy<%s>{version: %s}", p.Host, p.Version)
+}
+
+// RegisterProxy stores the proxy for the given host.
+func (s *Store) RegisterProxy(host string) (*Store, error) {
+	p, err := s.NewProxy(host, "").Register()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = p.GetSnapshot()
+	return s, nil
+}
+
+// UnregisterProxy removes the proxy for the given host from the store.
+func (s *Store) UnregisterProxy(host string) error {
+	return s.NewProxy(host, "").Unregister()
+}
+
+// GetProxies gets the list of bazooka-proxy service IPs
+func (s *Store) GetProxies() ([]string, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getHosts(sp, proxyDir)
+}
+
+// GetProxy fetches the Proxy for the given host.
+func (s *Store) GetProxy(host string) (*Proxy, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getProxy(host, sp)
+}
+
+// ListProxies returns all registered Proxies, the ones without a recent
+// heartbeat are flagged as Stale.
+func (s *Store) ListProxies() ([]*Proxy, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := getHosts(sp, proxyDir)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = nil
+		}
+		return []*Proxy{}, err
+	}
+
+	proxies := []*Proxy{}
+	ch, errch := cp.GetSnapshotables(hosts, func(host string) (cp.Snapshotable, error) {
+		return getProxy(host, sp)
+	})
+	for i := 0; i < len(hosts); i++ {
+		select {
+		case p := <-ch:
+			proxies = append(proxies, p.(*Proxy))
+		case err := <-errch:
+			return nil, err
+		}
+	}
+	return proxies, nil
+}
+
+// WatchProxies sends every registered Proxy over reg and the host of every
+// unregistered Proxy over unreg. Either channel can be nil.
+func (s *Store) WatchProxies(reg chan *Proxy, unreg chan string, errch chan error) {
+	errch <- watchServices(s, proxyDir, func(name string, ev cp.Event) error {
+		if reg == nil {
+			return nil
+		}
+		p, err := getProxy(decodeHost(name), ev)
+		if err != nil {
+			return err
+		}
+		reg <- p
+		return nil
+	}, func(name string) error {
+		if unreg != nil {
+			unreg <- decodeHost(name)
+		}
+		return nil
+	})
+}
+
+func getProxy(host string, s cp.Snapshotable) (*Proxy, error) {
+	p := storeFromSnapshotable(s).NewProxy(host, "")
+
+	attrs := &serviceAttrs{}
+	_, err := p.dir.GetFile(serviceAttrsPath, &cp.JsonCodec{DecodedVal: attrs})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, `proxy "%s" not found`, host)
+		}
+		return nil, err
+	}
+	p.Version = attrs.Version
+	if attrs.Meta != nil {
+		p.Meta = attrs.Meta
+	}
+
+	p.Registered, p.Heartbeat, err = getServiceTimes(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	p.Stale = isStale(p.Heartbeat)
+
+	return p, nil
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func proxySetup() *Store {
+	s, err := DialURI(DefaultURI, "/proxy-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestProxyRegisterAndGet(t *testing.T) {
+	s := proxySetup()
+
+	p := s.NewProxy("10.0.0.1", "v1")
+	p.Meta["zone"] = "a"
+	p, err := p.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1, err := s.GetProxy("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "v1", p1.Version; want != have {
+		t.Errorf("want version %s, have %s", want, have)
+	}
+	if want, have := "a", p1.Meta["zone"]; want != have {
+		t.Errorf("want meta %s, have %s", want, have)
+	}
+	if p1.Stale {
+		t.Error("expected proxy not to be stale")
+	}
+
+	proxies, err := s.ListProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 1 || proxies[0].Host != p.Host {
+		t.Errorf("unexpected proxies: %v", proxies)
+	}
+
+	if err := s.UnregisterProxy(p.Host); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProxy(p.Host); !IsErrNotFound(err) {
+		t.Errorf("expected proxy to be unregistered, got %v", err)
+	}
+}
+
+func TestProxyStale(t *testing.T) {
+	s := proxySetup()
+
+	defer func(ttl time.Duration) { HeartbeatTTL = ttl }(HeartbeatTTL)
+	HeartbeatTTL = time.Second
+
+	p, err := s.NewProxy("10.0.0.1", "v1").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+
+	p, err = s.GetProxy(p.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Stale {
+		t.Error("expected proxy to be stale")
+	}
+
+	p, err = p.SendHeartbeat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = s.GetProxy(p.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Stale {
+		t.Error("expected proxy not to be stale after heartbeat")
+	}
+}
+
+func TestWatchProxies(t *testing.T) {
+	var (
+		s     = proxySetup()
+		reg   = make(chan *Proxy)
+		unreg = make(chan string)
+		errch = make(chan error)
+	)
+
+	go s.WatchProxies(reg, unreg, errch)
+
+	p, err := s.NewProxy("10.0.0.1", "v1").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p1 := <-reg:
+		if p1.Host != p.Host {
+			t.Errorf("received unexpected proxy: %s", p1)
+		}
+	case err := <-errch:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("expected proxy, got timeout")
+	}
+
+	if err := p.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case host := <-unreg:
+		if host != p.Host {
+			t.Errorf("received unexpected host: %s", host)
+		}
+	case err := <-errch:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("expected host, got timeout")
+	}
+}
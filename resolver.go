@@ -0,0 +1,149 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Endpoint is a running Instance of a proc which can be called by clients.
+type Endpoint struct {
+	InstanceID int64  `json:"id"`
+	IP         string `json:"ip"`
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	TelePort   int    `json:"telePort"`
+	Rev        string `json:"rev"`
+	Env        string `json:"env"`
+	Weight     int    `json:"weight"`
+}
+
+// Addr returns the host:port of the Endpoint.
+func (e Endpoint) Addr() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Resolve returns the Endpoints of all running Instances of the given app and
+// proc, weighted by the TrafficControl share of the proc.
+func (s *Store) Resolve(app, proc string) ([]Endpoint, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return resolve(storeFromSnapshotable(sp), app, proc)
+}
+
+// WatchResolve sends the Endpoints of the given app and proc over ch every
+// time the set of Endpoints changes, starting with the current one.
+func (s *Store) WatchResolve(app, proc string, ch chan []Endpoint, errch chan error) {
+	s, err := s.FastForward()
+	if err != nil {
+		errch <- err
+		return
+	}
+	last, err := resolve(s, app, proc)
+	if err != nil {
+		errch <- err
+		return
+	}
+	ch <- last
+
+	var (
+		evch  = make(chan *Event)
+		everr = make(chan error, 1)
+	)
+	go func() {
+		everr <- s.WatchEvent(
+			evch,
+			EvInsStart,
+			EvInsStop,
+			EvInsExit,
+			EvInsFail,
+			EvInsLost,
+			EvInsUnreg,
+			EvProcAttrs,
+			EvProcUnreg,
+		)
+	}()
+
+	for {
+		select {
+		case ev := <-evch:
+			endpoints, err := resolve(storeFromSnapshotable(ev.raw), app, proc)
+			if err != nil {
+				errch <- err
+				return
+			}
+			if reflect.DeepEqual(endpoints, last) {
+				continue
+			}
+			last = endpoints
+			ch <- endpoints
+		case err := <-everr:
+			errch <- err
+			return
+		}
+	}
+}
+
+func resolve(s *Store, app, proc string) ([]Endpoint, error) {
+	a, err := getApp(app, s)
+	if err != nil {
+		return nil, err
+	}
+	p, err := getProc(a, proc, s)
+	if err != nil {
+		return nil, err
+	}
+	return p.getEndpoints()
+}
+
+// getEndpoints returns the Endpoints of all running Instances of the proc
+// ordered by Instance id.
+func (p *Proc) getEndpoints() ([]Endpoint, error) {
+	endpoints := []Endpoint{}
+
+	instances, err := p.GetInstances()
+	if err != nil {
+		if IsErrNotFound(err) {
+			return endpoints, nil
+		}
+		return nil, err
+	}
+
+	weight := DefaultWeight
+	if p.Attrs.TrafficControl != nil {
+		weight = p.Attrs.TrafficControl.Share
+	}
+
+	for _, ins := range instances {
+		if ins.Status != InsStatusRunning {
+			continue
+		}
+		endpoints = append(endpoints, Endpoint{
+			InstanceID: ins.ID,
+			IP:         ins.IP,
+			Host:       ins.Host,
+			Port:       ins.Port,
+			TelePort:   ins.TelePort,
+			Rev:        ins.RevisionName,
+			Env:        ins.Env,
+			Weight:     weight,
+		})
+	}
+	sort.Sort(endpointsByID(endpoints))
+
+	return endpoints, nil
+}
+
+type endpointsByID []Endpoint
+
+func (e endpointsByID) Len() int           { return len(e) }
+func (e endpointsByID) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e endpointsByID) Less(i, j int) bool { return e[i].InstanceID < e[j].InstanceID }
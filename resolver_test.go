@@ -0,0 +1,122 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func resolverSetup() (*Store, *Proc) {
+	s, err := DialURI(DefaultURI, "/resolver-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		panic(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		panic(err)
+	}
+	return s, proc
+}
+
+func resolverStartInstance(s *Store, ip string) *Instance {
+	ins, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		panic(err)
+	}
+	ins, err = ins.Claim(ip)
+	if err != nil {
+		panic(err)
+	}
+	ins, err = ins.Started(ip, "box.cat.com", 9000, 9001)
+	if err != nil {
+		panic(err)
+	}
+	return ins
+}
+
+func TestResolve(t *testing.T) {
+	s, _ := resolverSetup()
+
+	ins := resolverStartInstance(s, "10.0.0.1")
+	if _, err := s.RegisterInstance("cat", "128af9", "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints, err := s.Resolve("cat", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 1 {
+		t.Fatalf("expected 1 endpoint, got %#v", endpoints)
+	}
+	want := Endpoint{
+		InstanceID: ins.ID,
+		IP:         "10.0.0.1",
+		Host:       "box.cat.com",
+		Port:       9000,
+		TelePort:   9001,
+		Rev:        "128af9",
+		Env:        "default",
+		Weight:     DefaultWeight,
+	}
+	if have := endpoints[0]; want != have {
+		t.Errorf("want endpoint %#v, have %#v", want, have)
+	}
+
+	_, err = s.Resolve("cat", "worker")
+	if !IsErrNotFound(err) {
+		t.Errorf("expected ErrNotFound for missing proc, got %v", err)
+	}
+}
+
+func TestWatchResolve(t *testing.T) {
+	s, _ := resolverSetup()
+
+	ch := make(chan []Endpoint)
+	errch := make(chan error)
+	go s.WatchResolve("cat", "web", ch, errch)
+
+	select {
+	case endpoints := <-ch:
+		if len(endpoints) != 0 {
+			t.Fatalf("expected no endpoints, got %#v", endpoints)
+		}
+	case err := <-errch:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("expected initial endpoints")
+	}
+
+	ins := resolverStartInstance(s, "10.0.0.1")
+
+	select {
+	case endpoints := <-ch:
+		if len(endpoints) != 1 || endpoints[0].InstanceID != ins.ID {
+			t.Errorf("expected endpoint of %d, got %#v", ins.ID, endpoints)
+		}
+	case err := <-errch:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("expected endpoints to change")
+	}
+}
@@ -8,6 +8,7 @@ package visor
 import (
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
@@ -19,10 +20,12 @@ var RefFormat = regexp.MustCompile(`^[[:alnum:]\-\.]+$`)
 // identifiable by its `ref`.
 type Revision struct {
 	dir        *cp.Dir
-	App        *App
-	Ref        string
-	ArchiveURL string
-	Registered time.Time
+	App        *App   `json:"-"`
+	Ref        string `json:"ref"`
+	ArchiveURL string `json:"archiveUrl"`
+	// DeployAttrs overlay the App's, e.g. with the image of the revision.
+	DeployAttrs *DeployAttrs `json:"deployAttrs,omitempty"`
+	Registered  time.Time    `json:"registered"`
 }
 
 const (
@@ -45,6 +48,9 @@ func (r *Revision) GetSnapshot() cp.Snapshot {
 
 // Register registers a new Revision with the registry.
 func (r *Revision) Register() (*Revision, error) {
+	if err := authorize(r, r.App.Name, RoleDeployer); err != nil {
+		return nil, err
+	}
 	sp, err := r.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -57,11 +63,20 @@ func (r *Revision) Register() (*Revision, error) {
 	if exists {
 		return nil, ErrConflict
 	}
+	if err := r.validateDeploy(sp); err != nil {
+		return nil, err
+	}
 
 	d, err := r.dir.Join(sp).Set(archiveURLPath, r.ArchiveURL)
 	if err != nil {
 		return nil, err
 	}
+	if r.DeployAttrs != nil {
+		f := cp.NewFile(r.dir.Prefix(deployAttrsPath), r.DeployAttrs, new(cp.JsonCodec), sp)
+		if _, err := f.Save(); err != nil {
+			return nil, err
+		}
+	}
 	reg := time.Now()
 	d, err = r.dir.Set(registeredPath, formatTime(reg))
 	if err != nil {
@@ -71,16 +86,67 @@ func (r *Revision) Register() (*Revision, error) {
 
 	r.dir = d
 
+	if err := audit(r, r.dir.Name, AuditRegister, nil, r.ArchiveURL); err != nil {
+		return nil, err
+	}
 	return r, nil
 }
 
-// Unregister unregisters a revision from the registry.
-func (r *Revision) Unregister() error {
+// Unregister unregisters a revision from the registry. It returns
+// ErrConflict listing the instances which aren't terminated yet and the tags
+// referencing the revision, unless force is set. Forcing unregisters the
+// tags first.
+func (r *Revision) Unregister(force bool) error {
+	if err := authorize(r, r.App.Name, RoleDeployer); err != nil {
+		return err
+	}
 	sp, err := r.GetSnapshot().FastForward()
 	if err != nil {
 		return err
 	}
-	return r.dir.Join(sp).Del("/")
+
+	live, err := r.App.liveInstances(sp)
+	if err != nil {
+		return err
+	}
+	refs := []string{}
+	for _, ins := range live {
+		if ins.RevisionName == r.Ref {
+			refs = append(refs, fmt.Sprintf("instance %d", ins.ID))
+		}
+	}
+	tags, err := r.App.getTags(sp)
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	for _, tag := range tags {
+		if tag.Ref == r.Ref {
+			refs = append(refs, fmt.Sprintf("tag %s", tag.Name))
+		}
+	}
+	if len(refs) > 0 {
+		if !force {
+			return errorf(ErrConflict, `revision "%s" is referenced by %s`, r.Ref, strings.Join(refs, ", "))
+		}
+		// Tags aren't left pointing at the removed revision.
+		for _, tag := range tags {
+			if tag.Ref != r.Ref {
+				continue
+			}
+			if err := tag.Unregister(); err != nil && !IsErrNotFound(err) {
+				return err
+			}
+		}
+		sp, err = sp.FastForward()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	return audit(r, r.dir.Name, AuditUnregister, nil, nil)
 }
 
 func (r *Revision) String() string {
@@ -151,5 +217,32 @@ func getRevision(app *App, ref string, s cp.Snapshotable) (*Revision, error) {
 		return nil, err
 	}
 
+	attrs := &DeployAttrs{}
+	_, err = r.dir.GetFile(deployAttrsPath, &cp.JsonCodec{DecodedVal: attrs})
+	if err == nil {
+		r.DeployAttrs = attrs
+	} else if !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
 	return r, nil
 }
+
+// validateDeploy checks the attrs the Revision would be deployed with
+// against the deploy type of its App. Revisions of apps which aren't
+// registered are deployed with the default type, the ones of apps with deploy
+// types predating the registry aren't checked.
+func (r *Revision) validateDeploy(sp cp.Snapshot) error {
+	deployType, attrs := DeployLXC, (*DeployAttrs)(nil)
+	app, err := getApp(r.App.Name, sp)
+	if err == nil {
+		deployType, attrs = app.DeployType, app.DeployAttrs
+	} else if !IsErrNotFound(err) {
+		return err
+	}
+	t, err := GetDeployType(deployType)
+	if err != nil {
+		return nil
+	}
+	return t.ValidateRevision(MergeDeployAttrs(attrs, r.DeployAttrs))
+}
@@ -6,6 +6,7 @@
 package visor
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -76,7 +77,7 @@ func TestRevisionUnregister(t *testing.T) {
 		t.Error(err)
 	}
 
-	err = rev.Unregister()
+	err = rev.Unregister(false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -90,6 +91,37 @@ func TestRevisionUnregister(t *testing.T) {
 	}
 }
 
+func TestRevisionUnregisterInUse(t *testing.T) {
+	s, app := revSetup()
+	rev, err := s.NewRevision(app, "master", "master.img").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewTag("stable", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance(app.Name, "master", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rev.Unregister(false)
+	if !IsErrConflict(err) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	want := fmt.Sprintf(`revision "master" is referenced by instance %d, tag stable`, ins.ID)
+	if have := err.Error(); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	if err := rev.Unregister(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.GetTag("stable"); !IsErrNotFound(err) {
+		t.Errorf("expected tag to be unregistered with the revision, got %v", err)
+	}
+}
+
 func TestRevisionGet(t *testing.T) {
 	_, app := revSetup()
 
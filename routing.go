@@ -0,0 +1,214 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/template"
+)
+
+// DefaultWeight is the weight of backends of procs without TrafficControl.
+const DefaultWeight = 100
+
+// Backend is a running Instance receiving traffic for a proc.
+type Backend struct {
+	InstanceID int64
+	Host       string
+	Port       int
+	Weight     int
+}
+
+// Addr returns the host:port of the Backend.
+func (b Backend) Addr() string {
+	return net.JoinHostPort(b.Host, strconv.Itoa(b.Port))
+}
+
+// Route holds all Backends of a proc.
+type Route struct {
+	App      string
+	Proc     string
+	Port     int
+	Backends []Backend
+}
+
+// Name returns the canonical name of the Route, usable as identifier in
+// proxy configurations.
+func (r *Route) Name() string {
+	return r.App + "-" + r.Proc
+}
+
+// RoutingTable contains the Routes of all procs ordered by app and proc.
+type RoutingTable []*Route
+
+// RouteRenderer turns a RoutingTable into a proxy configuration.
+type RouteRenderer interface {
+	Render(w io.Writer, t RoutingTable) error
+}
+
+// HAProxyRenderer renders a RoutingTable as HAProxy backend sections.
+type HAProxyRenderer struct{}
+
+// Render satisfies the RouteRenderer interface.
+func (HAProxyRenderer) Render(w io.Writer, t RoutingTable) error {
+	return haproxyTemplate.Execute(w, t)
+}
+
+// NginxRenderer renders a RoutingTable as nginx upstream blocks. Procs without
+// Backends are left out as nginx refuses empty upstreams.
+type NginxRenderer struct{}
+
+// Render satisfies the RouteRenderer interface.
+func (NginxRenderer) Render(w io.Writer, t RoutingTable) error {
+	return nginxTemplate.Execute(w, t)
+}
+
+var haproxyTemplate = template.Must(template.New("haproxy").Parse(`{{range .}}backend {{.Name}}
+    balance roundrobin
+{{range .Backends}}    server {{.InstanceID}} {{.Addr}} weight {{.Weight}}
+{{end}}
+{{end}}`))
+
+var nginxTemplate = template.Must(template.New("nginx").Parse(`{{range .}}{{if .Backends}}upstream {{.Name}} {
+{{range .Backends}}    server {{.Addr}}{{if .Weight}} weight={{.Weight}}{{else}} down{{end}};
+{{end}}}
+
+{{end}}{{end}}`))
+
+// GetRoutingTable returns the Routes of all procs. Only running Instances are
+// part of a Route, each weighted by the TrafficControl share of its proc.
+func (s *Store) GetRoutingTable() (RoutingTable, error) {
+	apps, err := s.GetApps()
+	if err != nil {
+		return nil, err
+	}
+
+	table := RoutingTable{}
+	for _, app := range apps {
+		procs, err := app.GetProcs()
+		if err != nil {
+			return nil, err
+		}
+		for _, proc := range procs {
+			route, err := proc.getRoute()
+			if err != nil {
+				return nil, err
+			}
+			table = append(table, route)
+		}
+	}
+	sort.Sort(table)
+
+	return table, nil
+}
+
+// RenderRoutingTable renders the current RoutingTable with the given renderer
+// and atomically replaces the file at path with the result.
+func (s *Store) RenderRoutingTable(path string, r RouteRenderer) error {
+	table, err := s.GetRoutingTable()
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := r.Render(buf, table); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// WatchRoutingTable renders the RoutingTable to path and re-renders it every
+// time an Instance starts, stops or exits, or proc attrs change.
+func (s *Store) WatchRoutingTable(path string, r RouteRenderer) error {
+	if err := s.RenderRoutingTable(path, r); err != nil {
+		return err
+	}
+
+	var (
+		ch   = make(chan *Event)
+		errc = make(chan error, 1)
+	)
+	go func() {
+		errc <- s.WatchEvent(ch, EvInsStart, EvInsStop, EvInsExit, EvProcAttrs)
+	}()
+
+	for {
+		select {
+		case ev := <-ch:
+			if err := storeFromSnapshotable(ev.raw).RenderRoutingTable(path, r); err != nil {
+				return err
+			}
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+func (t RoutingTable) Len() int      { return len(t) }
+func (t RoutingTable) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t RoutingTable) Less(i, j int) bool {
+	if t[i].App != t[j].App {
+		return t[i].App < t[j].App
+	}
+	return t[i].Proc < t[j].Proc
+}
+
+func (p *Proc) getRoute() (*Route, error) {
+	endpoints, err := p.getEndpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	route := &Route{
+		App:      p.App.Name,
+		Proc:     p.Name,
+		Port:     p.Port,
+		Backends: make([]Backend, len(endpoints)),
+	}
+	for i, e := range endpoints {
+		route.Backends[i] = Backend{
+			InstanceID: e.InstanceID,
+			Host:       e.Host,
+			Port:       e.Port,
+			Weight:     e.Weight,
+		}
+	}
+
+	return route, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// to path, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func routingSetup() *Store {
+	s, err := DialURI(DefaultURI, "/routing-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+var testRoutingTable = RoutingTable{
+	&Route{
+		App:  "cat",
+		Proc: "web",
+		Port: 8000,
+		Backends: []Backend{
+			{InstanceID: 1, Host: "10.0.0.1", Port: 9000, Weight: 100},
+			{InstanceID: 2, Host: "::1", Port: 9001, Weight: 0},
+		},
+	},
+	&Route{App: "dog", Proc: "worker", Port: 8001, Backends: []Backend{}},
+}
+
+func TestRoutingRenderHAProxy(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := (HAProxyRenderer{}).Render(buf, testRoutingTable); err != nil {
+		t.Fatal(err)
+	}
+	want := `backend cat-web
+    balance roundrobin
+    server 1 10.0.0.1:9000 weight 100
+    server 2 [::1]:9001 weight 0
+
+backend dog-worker
+    balance roundrobin
+
+`
+	if have := buf.String(); want != have {
+		t.Errorf("want:\n%s\nhave:\n%s", want, have)
+	}
+}
+
+func TestRoutingRenderNginx(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := (NginxRenderer{}).Render(buf, testRoutingTable); err != nil {
+		t.Fatal(err)
+	}
+	want := `upstream cat-web {
+    server 10.0.0.1:9000 weight=100;
+    server [::1]:9001 down;
+}
+
+`
+	if have := buf.String(); want != have {
+		t.Errorf("want:\n%s\nhave:\n%s", want, have)
+	}
+}
+
+func TestRoutingTable(t *testing.T) {
+	s := routingSetup()
+
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.TrafficControl = &TrafficControl{Share: 30}
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err = running.Claim("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err = running.Started("10.0.0.1", "box1.cat.com", 9000, 9001)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopping, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopping, err = stopping.Claim("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopping, err = stopping.Started("10.0.0.2", "box2.cat.com", 9000, 9001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stopping.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RegisterInstance("cat", "128af9", "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := s.GetRoutingTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 1 {
+		t.Fatalf("expected 1 route, got %d", len(table))
+	}
+	route := table[0]
+	if want, have := "cat-web", route.Name(); want != have {
+		t.Errorf("want route %s, have %s", want, have)
+	}
+	if len(route.Backends) != 1 {
+		t.Fatalf("expected 1 backend, got %#v", route.Backends)
+	}
+	want := Backend{InstanceID: running.ID, Host: "box1.cat.com", Port: 9000, Weight: 30}
+	if have := route.Backends[0]; want != have {
+		t.Errorf("want backend %#v, have %#v", want, have)
+	}
+
+	dir, err := ioutil.TempDir("", "routing-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "upstreams.conf")
+	if err := s.RenderRoutingTable(file, NginxRenderer{}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("server box1.cat.com:9000 weight=30;")) {
+		t.Errorf("unexpected config:\n%s", b)
+	}
+}
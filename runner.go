@@ -6,7 +6,7 @@
 package visor
 
 import (
-	"fmt"
+	"net"
 	"path"
 	"strconv"
 	"strings"
@@ -19,17 +19,22 @@ const runnersPath = "runners"
 // Runner is representation of a bazooka-runner process.
 type Runner struct {
 	dir        *cp.Dir
-	Addr       string
-	InstanceID int64
+	Addr       string `json:"addr"`
+	InstanceID int64  `json:"instanceId"`
 }
 
-// NewRunner creates a Runner for the given Instance.
-func (s *Store) NewRunner(addr string, instanceID int64) *Runner {
+// NewRunner creates a Runner for the given Instance. It returns
+// ErrInvalidArgument if addr is not of the form host:port.
+func (s *Store) NewRunner(addr string, instanceID int64) (*Runner, error) {
+	p, err := runnerPath(addr)
+	if err != nil {
+		return nil, err
+	}
 	return &Runner{
-		dir:        cp.NewDir(runnerPath(addr), s.GetSnapshot()),
+		dir:        cp.NewDir(p, s.GetSnapshot()),
 		Addr:       addr,
 		InstanceID: instanceID,
-	}
+	}, nil
 }
 
 // GetSnapshot satisfies the cp.Snapshotable interface.
@@ -79,7 +84,7 @@ func (s *Store) Runners() (runners []*Runner, err error) {
 	}
 
 	for _, host := range hosts {
-		rns, err := s.RunnersByHost(host)
+		rns, err := s.RunnersByHost(decodeHost(host))
 		if err != nil {
 			return runners, err
 		}
@@ -94,7 +99,11 @@ func (s *Store) RunnersByHost(host string) ([]*Runner, error) {
 	if err != nil {
 		return nil, err
 	}
-	ids, err := sp.Getdir(path.Join(runnersPath, host))
+	name, err := encodeHost(host)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := sp.Getdir(path.Join(runnersPath, name))
 	if err != nil {
 		return nil, err
 	}
@@ -169,14 +178,19 @@ func (s *Store) WatchRunnerStop(ch chan string, errch chan error) {
 
 func addrFromPath(path string) string {
 	parts := strings.Split(path, "/")
-	addr := runnerAddr(parts[2], parts[3])
-
-	return addr
+	if len(parts) < 4 {
+		return path
+	}
+	return runnerAddr(decodeHost(parts[2]), parts[3])
 }
 
 func getRunner(addr string, s cp.Snapshotable) (*Runner, error) {
 	sp := s.GetSnapshot()
-	f, err := sp.GetFile(runnerPath(addr), new(cp.ListCodec))
+	p, err := runnerPath(addr)
+	if err != nil {
+		return nil, err
+	}
+	f, err := sp.GetFile(p, new(cp.ListCodec))
 	if err != nil {
 		if cp.IsErrNoEnt(err) {
 			err = errorf(ErrNotFound, "runner '%s' not found", addr)
@@ -190,7 +204,7 @@ func getRunner(addr string, s cp.Snapshotable) (*Runner, error) {
 		return nil, err
 	}
 
-	return storeFromSnapshotable(sp).NewRunner(addr, insID), nil
+	return storeFromSnapshotable(sp).NewRunner(addr, insID)
 }
 
 func waitRunners(s cp.Snapshotable) (cp.Event, error) {
@@ -199,10 +213,17 @@ func waitRunners(s cp.Snapshotable) (cp.Event, error) {
 }
 
 func runnerAddr(host, port string) string {
-	return fmt.Sprintf("%s:%s", host, port)
+	return net.JoinHostPort(host, port)
 }
 
-func runnerPath(addr string) string {
-	parts := strings.Split(addr, ":")
-	return path.Join(runnersPath, parts[0], parts[1])
+func runnerPath(addr string) (string, error) {
+	host, port, err := splitAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	name, err := encodeHost(host)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(runnersPath, name, port), nil
 }
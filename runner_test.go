@@ -34,7 +34,11 @@ func TestRunnerRegisterAndGet(t *testing.T) {
 	s := runnerSetup()
 	addr := "127.0.0.1:9999"
 
-	r, err := s.NewRunner(addr, insID).Register()
+	r, err := s.NewRunner(addr, insID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = r.Register()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,15 +75,27 @@ func TestRunnerRegisterAndGet(t *testing.T) {
 func TestRunnersByHost(t *testing.T) {
 	s := runnerSetup()
 
-	_, err := s.NewRunner("10.0.1.1:7777", 9).Register()
+	r, err := s.NewRunner("10.0.1.1:7777", 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = s.NewRunner("10.0.1.2:7777", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Register()
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = s.NewRunner("10.0.1.2:7777", 7).Register()
+	r, err = s.NewRunner("10.0.1.2:7778", 8)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = s.NewRunner("10.0.1.2:7778", 8).Register()
+	_, err = r.Register()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -126,7 +142,10 @@ func TestWatchRunnerStart(t *testing.T) {
 
 	go s.WatchRunnerStart(ch, errch)
 
-	r := s.NewRunner(addr, insID)
+	r, err := s.NewRunner(addr, insID)
+	if err != nil {
+		t.Fatal(err)
+	}
 	r1, err := r.Register()
 	if err != nil {
 		t.Fatal(err)
@@ -155,7 +174,10 @@ func TestWatchRunnerStop(t *testing.T) {
 
 	go s.WatchRunnerStop(ch, errch)
 
-	r := s.NewRunner(addr, insID)
+	r, err := s.NewRunner(addr, insID)
+	if err != nil {
+		t.Fatal(err)
+	}
 	r1, err := r.Register()
 	if err != nil {
 		t.Fatal(err)
@@ -177,3 +199,42 @@ func TestWatchRunnerStop(t *testing.T) {
 		t.Errorf("expected runner, got timeout")
 	}
 }
+
+func TestRunnerIPv6(t *testing.T) {
+	s := runnerSetup()
+	addr := "[::1]:9000"
+
+	r, err := s.NewRunner(addr, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := s.GetRunner(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.Addr != addr {
+		t.Errorf("want addr %s, have %s", addr, r1.Addr)
+	}
+
+	rs, err := s.RunnersByHost("::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].Addr != addr {
+		t.Errorf("unexpected runners for host: %v", rs)
+	}
+}
+
+func TestRunnerInvalidAddr(t *testing.T) {
+	s := runnerSetup()
+
+	for _, addr := range []string{"", "10.0.0.1", "10.0.0.1:port", "::1:9000", "a/b:9000"} {
+		if _, err := s.NewRunner(addr, 1); !IsErrInvalidArgument(err) {
+			t.Errorf("expected %q to be rejected, got %v", addr, err)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultInstanceMemoryMb is the memory accounted for an Instance whose proc
+// has no memory limit set.
+const DefaultInstanceMemoryMb = 512
+
+// PmLoad describes the current utilisation of a pm.
+type PmLoad struct {
+	Host      string
+	Pm        *Pm
+	MemoryMb  int
+	Instances []*Instance
+}
+
+// PlacementRule decides if the given Instance may be placed on a pm with the
+// given load.
+type PlacementRule func(ins *Instance, load *PmLoad) bool
+
+// MaxPerProc limits the number of instances of the same app and proc which
+// can be placed on a single pm.
+func MaxPerProc(n int) PlacementRule {
+	return func(ins *Instance, load *PmLoad) bool {
+		count := 0
+		for _, i := range load.Instances {
+			if i.ServiceName() == ins.ServiceName() {
+				count++
+			}
+		}
+		return count < n
+	}
+}
+
+// MatchLabels only allows pms which carry all of the given labels.
+func MatchLabels(labels map[string]string) PlacementRule {
+	return func(ins *Instance, load *PmLoad) bool {
+		for k, v := range labels {
+			if load.Pm.Labels[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Scheduler assigns pending Instances to registered pms. Placement is
+// deterministic: among all live, uncordoned pms with enough capacity and
+// passing the placement rules, the one with the least memory reserved wins,
+// ties are broken by number of instances, previous claims and finally the host
+// name.
+type Scheduler struct {
+	store *Store
+	Rules []PlacementRule
+	// MemoryMb is the memory capacity assumed for pms which didn't register
+	// one, zero means unlimited.
+	MemoryMb int
+}
+
+// NewScheduler returns a Scheduler given a set of placement rules.
+func (s *Store) NewScheduler(rules ...PlacementRule) *Scheduler {
+	return &Scheduler{
+		store: s,
+		Rules: rules,
+	}
+}
+
+// Schedule picks a pm for the given pending Instance and assigns it. It
+// returns ErrNoCapacity if no pm is able to take the Instance.
+func (s *Scheduler) Schedule(ins *Instance) (*Instance, error) {
+	if ins.Status != InsStatusPending {
+		return nil, errorf(ErrInvalidState, "%s is not pending", ins)
+	}
+
+	loads, memory, err := s.loads()
+	if err != nil {
+		return nil, err
+	}
+	claims, err := ins.Claims()
+	if err != nil {
+		return nil, err
+	}
+
+	need, err := memory.get(ins)
+	if err != nil {
+		return nil, err
+	}
+	deployType, err := s.deployType(ins)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *PmLoad
+	for _, load := range loads {
+		if deployType != "" && !load.Pm.Supports(deployType) {
+			continue
+		}
+		if !s.fits(ins, load, need) {
+			continue
+		}
+		if best == nil || lessLoaded(load, best, claims) {
+			best = load
+		}
+	}
+	if best == nil {
+		return nil, errorf(ErrNoCapacity, "no pm can take %s", ins)
+	}
+
+	return ins.Assign(best.Host)
+}
+
+// Reassign schedules the pending Instances again which are assigned to pms
+// that are unregistered, stale or cordoned. Instances no other pm can take
+// are unassigned, so any pm may claim them. It returns the Instances changed.
+func (s *Scheduler) Reassign() ([]*Instance, error) {
+	loads, _, err := s.loads()
+	if err != nil {
+		return nil, err
+	}
+	available := map[string]bool{}
+	for _, load := range loads {
+		available[load.Host] = !load.Pm.Cordoned && !load.Pm.Stale
+	}
+	store, err := s.store.FastForward()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := store.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+
+	changed := []*Instance{}
+	for _, ins := range instances {
+		if ins.Status != InsStatusPending || ins.Assignee == "" || available[ins.Assignee] {
+			continue
+		}
+		moved, err := s.Schedule(ins)
+		if IsErrNoCapacity(err) {
+			moved, err = ins.Unassign()
+		}
+		if IsErrInsClaimed(err) || IsErrNotFound(err) {
+			continue
+		} else if err != nil {
+			return changed, err
+		}
+		changed = append(changed, moved)
+	}
+	return changed, nil
+}
+
+// Run watches for registered and unclaimed Instances and schedules them.
+// Instances which can't be placed are retried whenever an Instance goes away.
+// Every HeartbeatTTL the Instances assigned to pms which went away are
+// reassigned, see Reassign.
+func (s *Scheduler) Run() error {
+	var (
+		backlog = map[int64]bool{}
+		ch      = make(chan *Event)
+		errc    = make(chan error, 1)
+		ticker  = time.NewTicker(HeartbeatTTL)
+	)
+	defer ticker.Stop()
+
+	go func() {
+		errc <- s.store.WatchEvent(
+			ch,
+			EvInsReg, EvInsUnclaim,
+			EvInsUnreg, EvInsExit, EvInsFail, EvInsLost,
+		)
+	}()
+
+	for {
+		select {
+		case ev := <-ch:
+			if ins, ok := ev.Source.(*Instance); ok && (ev.Type == EvInsReg || ev.Type == EvInsUnclaim) {
+				backlog[ins.ID] = true
+			}
+			for id := range backlog {
+				ins, err := s.store.GetInstance(id)
+				if IsErrNotFound(err) {
+					delete(backlog, id)
+					continue
+				} else if err != nil {
+					return err
+				}
+				if ins.Status != InsStatusPending {
+					delete(backlog, id)
+					continue
+				}
+				_, err = s.Schedule(ins)
+				if IsErrNoCapacity(err) || IsErrInsClaimed(err) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				delete(backlog, id)
+			}
+		case <-ticker.C:
+			if _, err := s.Reassign(); err != nil {
+				return err
+			}
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+func (s *Scheduler) fits(ins *Instance, load *PmLoad, need int) bool {
+	if load.Pm.Cordoned || load.Pm.Stale {
+		return false
+	}
+	capacity := load.Pm.Capacity.MemoryMb
+	if capacity == 0 {
+		capacity = s.MemoryMb
+	}
+	if capacity > 0 && load.MemoryMb+need > capacity {
+		return false
+	}
+	max := load.Pm.Capacity.MaxInstances
+	if max > 0 && len(load.Instances) >= max {
+		return false
+	}
+	for _, rule := range s.Rules {
+		if !rule(ins, load) {
+			return false
+		}
+	}
+	return true
+}
+
+// deployType returns the deploy type of the Instance's app, or an empty
+// string if the app isn't registered or its deploy type predates the
+// registry.
+func (s *Scheduler) deployType(ins *Instance) (string, error) {
+	app, err := s.store.GetApp(ins.AppName)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !isKnownDeployType(app.DeployType) {
+		return "", nil
+	}
+	return app.DeployType, nil
+}
+
+func (s *Scheduler) loads() ([]*PmLoad, *memoryLimits, error) {
+	store, err := s.store.FastForward()
+	if err != nil {
+		return nil, nil, err
+	}
+	hosts, err := store.GetPms()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, nil, err
+	}
+	sort.Strings(hosts)
+
+	var (
+		loads  = []*PmLoad{}
+		byHost = map[string]*PmLoad{}
+		memory = &memoryLimits{store: store, limits: map[string]int{}}
+	)
+	for _, host := range hosts {
+		pm, err := getPm(host, store)
+		if IsErrNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		load := &PmLoad{Host: host, Pm: pm}
+		byHost[host] = load
+		loads = append(loads, load)
+	}
+
+	instances, err := store.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, nil, err
+	}
+	for _, ins := range instances {
+		switch ins.Status {
+		case InsStatusClaimed, InsStatusRunning, InsStatusStopping:
+		default:
+			continue
+		}
+		load, ok := byHost[ins.IP]
+		if !ok {
+			continue
+		}
+		mb, err := memory.get(ins)
+		if err != nil {
+			return nil, nil, err
+		}
+		load.MemoryMb += mb
+		load.Instances = append(load.Instances, ins)
+	}
+
+	return loads, memory, nil
+}
+
+func lessLoaded(a, b *PmLoad, claims []string) bool {
+	if a.MemoryMb != b.MemoryMb {
+		return a.MemoryMb < b.MemoryMb
+	}
+	if len(a.Instances) != len(b.Instances) {
+		return len(a.Instances) < len(b.Instances)
+	}
+	aClaimed, bClaimed := containsString(claims, a.Host), containsString(claims, b.Host)
+	if aClaimed != bClaimed {
+		return bClaimed
+	}
+	return a.Host < b.Host
+}
+
+// memoryLimits caches the memory limit per app and proc.
+type memoryLimits struct {
+	store  *Store
+	limits map[string]int
+}
+
+func (m *memoryLimits) get(ins *Instance) (int, error) {
+	if mb, ok := m.limits[ins.ServiceName()]; ok {
+		return mb, nil
+	}
+
+	mb := DefaultInstanceMemoryMb
+
+	app, err := m.store.GetApp(ins.AppName)
+	if err != nil && !IsErrNotFound(err) {
+		return -1, err
+	}
+	if app != nil {
+		proc, err := app.GetProc(ins.ProcessName)
+		if err != nil && !IsErrNotFound(err) {
+			return -1, err
+		}
+		if proc != nil && proc.Attrs.Limits.MemoryLimitMb != nil {
+			mb = *proc.Attrs.Limits.MemoryLimitMb
+		}
+	}
+	m.limits[ins.ServiceName()] = mb
+
+	return mb, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+)
+
+func schedulerSetup(pms ...string) *Store {
+	s, err := DialURI(DefaultURI, "/scheduler-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	for _, pm := range pms {
+		s, err = s.RegisterPm(pm, "v1")
+		if err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+func TestSchedulerLeastLoaded(t *testing.T) {
+	s := schedulerSetup("10.0.0.1", "10.0.0.2")
+
+	busy, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := busy.Claim("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = s.NewScheduler().Schedule(ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "10.0.0.2", ins.Assignee; want != have {
+		t.Errorf("want assignee %s, have %s", want, have)
+	}
+
+	_, err = ins.Claim("10.0.0.1")
+	if !IsErrUnauthorized(err) {
+		t.Errorf("expected claim of unassigned host to fail, got %v", err)
+	}
+	if _, err := ins.Claim("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedulerMemoryCapacity(t *testing.T) {
+	var (
+		s     = schedulerSetup("10.0.0.1")
+		app   = s.NewApp("dog", "git://dog.git", "stack")
+		limit = 256
+	)
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.Limits.MemoryLimitMb = &limit
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler := s.NewScheduler()
+	scheduler.MemoryMb = 300
+
+	ins, err := s.RegisterInstance("dog", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = scheduler.Schedule(ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim(ins.Assignee); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err = s.RegisterInstance("dog", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = scheduler.Schedule(ins)
+	if !IsErrNoCapacity(err) {
+		t.Errorf("expected ErrNoCapacity, got %v", err)
+	}
+}
+
+func TestSchedulerMaxPerProc(t *testing.T) {
+	s := schedulerSetup("10.0.0.1", "10.0.0.2")
+	scheduler := s.NewScheduler(MaxPerProc(1))
+
+	hosts := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		ins, err := s.RegisterInstance("bird", "128af9", "web", "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins, err = scheduler.Schedule(ins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ins.Claim(ins.Assignee); err != nil {
+			t.Fatal(err)
+		}
+		hosts[ins.Assignee] = true
+	}
+	if len(hosts) != 2 {
+		t.Errorf("expected instances to be spread, got %v", hosts)
+	}
+
+	ins, err := s.RegisterInstance("bird", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = scheduler.Schedule(ins)
+	if !IsErrNoCapacity(err) {
+		t.Errorf("expected ErrNoCapacity, got %v", err)
+	}
+}
+
+func TestSchedulerReassign(t *testing.T) {
+	s := schedulerSetup("10.0.0.1", "10.0.0.2")
+	scheduler := s.NewScheduler()
+
+	ins, err := s.RegisterInstance("cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = ins.Assign("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := s.GetPm("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.Cordon(); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := scheduler.Reassign()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0].Assignee != "10.0.0.2" {
+		t.Fatalf("expected instance to be moved to 10.0.0.2, got %v", changed)
+	}
+
+	pm, err = s.GetPm("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	ins, err = s.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Assignee != "" {
+		t.Errorf("expected unregistered pm to release its assignments, got %s", ins.Assignee)
+	}
+	if _, err := ins.Claim("10.0.0.3"); err != nil {
+		t.Errorf("expected unassigned instance to be claimed by any pm, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const secretsPath = "secrets"
+
+// Redacted replaces the values of secrets in Envs read from the store.
+const Redacted = "[redacted]"
+
+// KeyProvider supplies the AES-256 keys secrets are encrypted with. Keys are
+// identified by an id stored along with each encrypted value, so secrets
+// encrypted with a retired key can still be read while they're rotated.
+type KeyProvider interface {
+	// CurrentKey returns the key new secrets are encrypted with.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id.
+	Key(id string) ([]byte, error)
+}
+
+// WithKeyProvider gives the Store the keys to encrypt and decrypt the
+// secrets of Envs. Without it secrets can't be registered or read.
+func WithKeyProvider(keys KeyProvider) StoreOption {
+	return func(s *Store) {
+		s.identity.keys = keys
+	}
+}
+
+// KeyFileProvider is a KeyProvider reading keys from a local file. Each
+// line of the file holds a key id and the base64 encoded 32 byte key
+// separated by ":". The last key is the current one, blank lines and lines
+// starting with "#" are ignored. To rotate, append a new key, run
+// RotateSecrets and remove the old key afterwards.
+type KeyFileProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyFileProvider reads the keys from the file at path.
+func NewKeyFileProvider(path string) (*KeyFileProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &KeyFileProvider{keys: map[string][]byte{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errorf(ErrInvalidFile, "invalid key line in %s", path)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, errorf(ErrInvalidFile, `invalid key "%s" in %s`, parts[0], path)
+		}
+		p.keys[parts[0]] = key
+		p.current = parts[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if p.current == "" {
+		return nil, errorf(ErrInvalidFile, "no keys in %s", path)
+	}
+	return p, nil
+}
+
+// CurrentKey satisfies the KeyProvider interface.
+func (p *KeyFileProvider) CurrentKey() (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+// Key satisfies the KeyProvider interface.
+func (p *KeyFileProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errorf(ErrNoKey, `key "%s" not found`, id)
+	}
+	return key, nil
+}
+
+// SecretKeys returns the sorted names of the secrets of the Env.
+func (e *Env) SecretKeys() []string {
+	keys := []string{}
+	for k := range e.sealed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DecryptSecrets returns the plain values of the secrets of the Env. It
+// fails with ErrNoKey unless the Store the Env was read from holds the keys
+// they were encrypted with.
+func (e *Env) DecryptSecrets() (map[string]string, error) {
+	keys := identityOf(e).keys
+	if keys == nil && len(e.sealed) > 0 {
+		return nil, errorf(ErrNoKey, "no key provider to decrypt secrets of env %s", e.Ref)
+	}
+	secrets := map[string]string{}
+	for k, sealed := range e.sealed {
+		v, err := openSecret(keys, k, sealed)
+		if err != nil {
+			return nil, err
+		}
+		secrets[k] = v
+	}
+	return secrets, nil
+}
+
+// RotateSecrets re-encrypts the secrets of all Envs of the App which aren't
+// encrypted with the current key, and returns their refs.
+func (a *App) RotateSecrets() ([]string, error) {
+	if err := authorize(a, a.Name, RoleOwner); err != nil {
+		return nil, err
+	}
+	keys := identityOf(a).keys
+	if keys == nil {
+		return nil, errorf(ErrNoKey, "no key provider to rotate secrets of app %s", a.Name)
+	}
+	current, _, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	envs, err := a.getEnvs(sp)
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
+	rotated := []string{}
+	for _, e := range envs {
+		sealed := map[string]string{}
+		stale := false
+		for k, v := range e.sealed {
+			if id, _ := splitSecret(v); id == current {
+				sealed[k] = v
+				continue
+			}
+			plain, err := openSecret(keys, k, v)
+			if err != nil {
+				return rotated, err
+			}
+			sealed[k], err = sealSecret(keys, k, plain)
+			if err != nil {
+				return rotated, err
+			}
+			stale = true
+		}
+		if !stale {
+			continue
+		}
+		f := cp.NewFile(e.dir.Prefix(secretsPath), sealed, new(cp.JsonCodec), sp)
+		if _, err := f.Save(); err != nil {
+			return rotated, err
+		}
+		if err := audit(e, e.dir.Prefix(secretsPath), AuditSetAttrs, nil, e.SecretKeys()); err != nil {
+			return rotated, err
+		}
+		rotated = append(rotated, e.Ref)
+	}
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+// RotateSecrets re-encrypts the secrets of the Envs of all Apps, archived
+// ones included, see App.RotateSecrets. It returns the refs of the rotated
+// Envs by App.
+func (s *Store) RotateSecrets() (map[string][]string, error) {
+	apps, err := s.GetApps()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := s.GetArchivedApps()
+	if err != nil {
+		return nil, err
+	}
+	apps = append(apps, archived...)
+	rotated := map[string][]string{}
+	for _, app := range apps {
+		refs, err := app.RotateSecrets()
+		if len(refs) > 0 {
+			rotated[app.Name] = refs
+		}
+		if err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, nil
+}
+
+// sealSecret encrypts the value of the secret named key with the current
+// key, the name is authenticated along with it.
+func sealSecret(keys KeyProvider, key, value string) (string, error) {
+	id, k, err := keys.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(k)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(key))
+	return id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openSecret(keys KeyProvider, key, sealed string) (string, error) {
+	id, data := splitSecret(sealed)
+	k, err := keys.Key(id)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(k)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) < gcm.NonceSize() {
+		return "", errorf(ErrInvalidFile, `invalid secret "%s"`, key)
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], []byte(key))
+	if err != nil {
+		return "", errorf(ErrNoKey, `can't decrypt secret "%s" with key "%s"`, key, id)
+	}
+	return string(plain), nil
+}
+
+// splitSecret returns the key id and the encrypted data of a sealed secret.
+func splitSecret(sealed string) (string, string) {
+	parts := strings.SplitN(sealed, ":", 2)
+	if len(parts) != 2 {
+		return "", sealed
+	}
+	return parts[0], parts[1]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errorf(ErrNoKey, "invalid key: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
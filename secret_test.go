@@ -0,0 +1,239 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func secretSetup(keys KeyProvider) (*Store, *App) {
+	s, err := DialURI(DefaultURI, "/secret-test", WithKeyProvider(keys))
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		panic(err)
+	}
+	return s, app
+}
+
+func keyFile(t *testing.T, ids ...string) *KeyFileProvider {
+	f, err := ioutil.TempFile("", "visor-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("# test keys\n\n")
+	for i, id := range ids {
+		key := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		f.WriteString(id + ":" + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	f.Close()
+
+	p, err := NewKeyFileProvider(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestKeyFileProvider(t *testing.T) {
+	p := keyFile(t, "2013-01", "2013-02")
+
+	id, _, err := p.CurrentKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "2013-02", id; want != have {
+		t.Errorf("want current key %s, have %s", want, have)
+	}
+	if _, err := p.Key("2012-12"); !IsErrNoKey(err) {
+		t.Errorf("expected ErrNoKey, got %v", err)
+	}
+
+	f, err := ioutil.TempFile("", "visor-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("short:" + base64.StdEncoding.EncodeToString([]byte("key")) + "\n")
+	f.Close()
+	if _, err := NewKeyFileProvider(f.Name()); !IsErrInvalidFile(err) {
+		t.Errorf("expected ErrInvalidFile for short key, got %v", err)
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	keys := keyFile(t, "a")
+
+	sealed, err := sealSecret(keys, "DB_PASSWORD", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, "a:") || strings.Contains(sealed, "hunter2") {
+		t.Errorf("unexpected sealed secret %s", sealed)
+	}
+	plain, err := openSecret(keys, "DB_PASSWORD", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "hunter2", plain; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if _, err := openSecret(keys, "API_TOKEN", sealed); !IsErrNoKey(err) {
+		t.Errorf("expected secret bound to its name, got %v", err)
+	}
+	if _, err := openSecret(keyFile(t, "b", "a"), "DB_PASSWORD", sealed); !IsErrNoKey(err) {
+		t.Errorf("expected ErrNoKey for another key, got %v", err)
+	}
+}
+
+func TestEnvSecrets(t *testing.T) {
+	keys := keyFile(t, "a")
+	s, app := secretSetup(keys)
+
+	env := app.NewEnv("first", map[string]string{"DB_USER": "cat"})
+	env.Secrets = map[string]string{"DB_PASSWORD": "hunter2"}
+	env, err := env.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := Redacted, env.Secrets["DB_PASSWORD"]; want != have {
+		t.Errorf("expected registered secret to be redacted, got %s", have)
+	}
+
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := sp.Get(env.dir.Prefix(secretsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(raw, "hunter2") {
+		t.Errorf("expected secret to be encrypted at rest, got %s", raw)
+	}
+
+	anon, err := DialURI(DefaultURI, "/secret-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := anon.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, err := a.GetEnv("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redacted.Secrets["DB_PASSWORD"] != Redacted || redacted.Vars["DB_USER"] != "cat" {
+		t.Errorf("expected secret to be redacted, got %#v", redacted)
+	}
+	if _, err := redacted.DecryptSecrets(); !IsErrNoKey(err) {
+		t.Errorf("expected ErrNoKey without key provider, got %v", err)
+	}
+
+	derived, err := env.Derive("second", map[string]string{"DB_USER": "dog"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err = app.GetEnv(derived.Ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := derived.DecryptSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "hunter2", secrets["DB_PASSWORD"]; want != have {
+		t.Errorf("want derived secret %s, have %s", want, have)
+	}
+	if _, err := env.Derive("plain", map[string]string{"DB_PASSWORD": "x"}, nil); !IsErrInvalidKey(err) {
+		t.Errorf("expected ErrInvalidKey for setting a secret, got %v", err)
+	}
+	unsealed, err := env.Derive("unsealed", nil, []string{"DB_PASSWORD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := DiffEnvs(derived, unsealed)
+	if len(d.RemovedSecrets) != 1 || d.RemovedSecrets[0] != "DB_PASSWORD" || len(d.AddedSecrets) != 0 {
+		t.Errorf("expected removed secret in diff, got %#v", d)
+	}
+	if d = DiffEnvs(unsealed, derived); len(d.AddedSecrets) != 1 || d.Empty() {
+		t.Errorf("expected added secret in diff, got %#v", d)
+	}
+
+	bad := app.NewEnv("third", map[string]string{"DB_PASSWORD": "x"})
+	bad.Secrets = map[string]string{"DB_PASSWORD": "y"}
+	if _, err := bad.Register(); !IsErrInvalidKey(err) {
+		t.Errorf("expected ErrInvalidKey for var and secret, got %v", err)
+	}
+}
+
+func TestRotateSecrets(t *testing.T) {
+	s, app := secretSetup(keyFile(t, "a"))
+
+	env := app.NewEnv("first", map[string]string{})
+	env.Secrets = map[string]string{"DB_PASSWORD": "hunter2"}
+	if _, err := env.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.NewEnv("plain", map[string]string{"A": "1"}).Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotating := s.With(WithKeyProvider(keyFile(t, "a", "b")))
+	rotated, err := rotating.RotateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := rotated["cat"]; len(refs) != 1 || refs[0] != "first" {
+		t.Errorf("expected env first to be rotated, got %v", rotated)
+	}
+	rotated, err = rotating.RotateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 0 {
+		t.Errorf("expected nothing left to rotate, got %v", rotated)
+	}
+
+	// The secret is readable without the retired key.
+	app, err = s.With(WithKeyProvider(keyFile(t, "c", "b"))).GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err = app.GetEnv("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := env.DecryptSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "hunter2", secrets["DB_PASSWORD"]; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"strings"
+)
+
+type selectorOp string
+
+const (
+	selectorEquals    = selectorOp("=")
+	selectorNotEquals = selectorOp("!=")
+	selectorExists    = selectorOp("")
+	selectorNotExists = selectorOp("!")
+)
+
+type requirement struct {
+	key   string
+	op    selectorOp
+	value string
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case selectorEquals:
+		return ok && v == r.value
+	case selectorNotEquals:
+		return !ok || v != r.value
+	case selectorExists:
+		return ok
+	case selectorNotExists:
+		return !ok
+	}
+	return false
+}
+
+func (r requirement) String() string {
+	if r.op == selectorNotExists {
+		return "!" + r.key
+	}
+	return r.key + string(r.op) + r.value
+}
+
+// Selector selects Apps by their labels. All of its requirements have to
+// match.
+type Selector []requirement
+
+// ParseSelector parses a comma separated list of label requirements:
+//
+//	key=value, key==value   label is set to value
+//	key!=value              label is not set or set to another value
+//	key                     label is set
+//	!key                    label is not set
+//
+// The empty selector matches everything.
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		var r requirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			r = requirement{key: kv[0], op: selectorNotEquals, value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			r = requirement{key: kv[0], op: selectorEquals, value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			r = requirement{key: part[1:], op: selectorNotExists}
+		default:
+			r = requirement{key: part, op: selectorExists}
+		}
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+
+		if err := validateLabel(r.key, r.value); err != nil {
+			return nil, errorf(ErrInvalidArgument, "invalid selector %q: %s", part, err)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches returns true if the labels satisfy all requirements.
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, r := range sel {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sel Selector) String() string {
+	parts := make([]string, len(sel))
+	for i, r := range sel {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if err := validateLabel(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLabel(k, v string) error {
+	if k == "" {
+		return errorf(ErrInvalidKey, "label keys can't be empty")
+	}
+	if strings.ContainsAny(k, "=!, ") {
+		return errorf(ErrInvalidKey, `label key %q can't contain "=", "!", "," or spaces`, k)
+	}
+	if strings.Contains(v, ",") {
+		return errorf(ErrInvalidKey, `label value %q can't contain ","`, v)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "search", "tier": "web"}
+
+	for selector, want := range map[string]bool{
+		"":                        true,
+		"team=search":             true,
+		"team==search":            true,
+		"team=search,tier!=batch": true,
+		"team=search, tier=batch": false,
+		"tier!=web":               false,
+		"owner!=bob":              true,
+		"team":                    true,
+		"!team":                   false,
+		"!owner":                  true,
+		"team=search,!owner,tier": true,
+	} {
+		sel, err := ParseSelector(selector)
+		if err != nil {
+			t.Fatalf("%q: %s", selector, err)
+		}
+		if have := sel.Matches(labels); want != have {
+			t.Errorf("%q: want %t, have %t", selector, want, have)
+		}
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{"=search", "team=search,", "!", "te am=search"} {
+		if _, err := ParseSelector(selector); !IsErrInvalidArgument(err) {
+			t.Errorf("%q: expected selector to be rejected, got %v", selector, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const (
+	heartbeatPath    = "heartbeat"
+	serviceAttrsPath = "attrs"
+)
+
+// HeartbeatTTL is the time after which a registered pm, proxy or logger
+// without a heartbeat is flagged as stale.
+var HeartbeatTTL = time.Minute
+
+// serviceAttrs are the attributes shared by all registered services.
+type serviceAttrs struct {
+	Version string            `json:"version"`
+	Meta    map[string]string `json:"meta"`
+}
+
+// registerService stores the attrs, heartbeat and registered files of a
+// service. The registered file is set last as it's the one being watched.
+func registerService(d *cp.Dir, attrs interface{}) (*cp.Dir, time.Time, error) {
+	sp, err := d.Snapshot.FastForward()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f := cp.NewFile(d.Prefix(serviceAttrsPath), attrs, new(cp.JsonCodec), sp)
+	f, err = f.Save()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	reg := time.Now()
+	d, err = d.Join(f).Set(heartbeatPath, formatTime(reg))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	d, err = d.Set(registeredPath, formatTime(reg))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return d, reg, nil
+}
+
+func heartbeatService(d *cp.Dir) (*cp.Dir, time.Time, error) {
+	sp, err := d.Snapshot.FastForward()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	exists, _, err := sp.Exists(d.Prefix(registeredPath))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !exists {
+		return nil, time.Time{}, errorf(ErrNotFound, "%s is not registered", d.Name)
+	}
+
+	now := time.Now()
+	d, err = d.Join(sp).Set(heartbeatPath, formatTime(now))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return d, now, nil
+}
+
+// getServiceTimes reads the registered and heartbeat times of a service.
+// Services registered before heartbeats were introduced report their
+// registration time as last heartbeat.
+func getServiceTimes(d *cp.Dir) (registered, heartbeat time.Time, err error) {
+	f, err := d.GetFile(registeredPath, new(cp.StringCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "registered not found for %s", d.Name)
+		}
+		return
+	}
+	registered, err = parseTime(f.Value.(string))
+	if err != nil {
+		return
+	}
+
+	f, err = d.GetFile(heartbeatPath, new(cp.StringCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return registered, registered, nil
+		}
+		return
+	}
+	heartbeat, err = parseTime(f.Value.(string))
+	return
+}
+
+func isStale(heartbeat time.Time) bool {
+	return time.Since(heartbeat) > HeartbeatTTL
+}
+
+// watchServices calls reg for every registration and unreg for every
+// unregistration of a service below dir.
+func watchServices(
+	s cp.Snapshotable,
+	dir string,
+	reg func(name string, ev cp.Event) error,
+	unreg func(name string) error,
+) error {
+	sp := s.GetSnapshot()
+	for {
+		ev, err := sp.Wait(path.Join(dir, "*", registeredPath))
+		if err != nil {
+			return err
+		}
+		sp = sp.Join(ev)
+
+		name := strings.Split(strings.TrimPrefix(ev.Path, dir+"/"), "/")[0]
+
+		if ev.IsSet() {
+			err = reg(name, ev)
+		} else if ev.IsDel() {
+			err = unreg(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// migrateServices moves the pms, proxies and loggers stored as a single file
+// holding "<registered> [version]" to their own directories with attrs,
+// heartbeat and registered files. Up to schema 7 all of them are stored that
+// way, schema 8 kept only proxies and loggers in files. The pm directories of
+// schema 8 hold attrs and registered files already and are left as they are,
+// their missing heartbeat is reported as the registration time.
+func migrateServices(sp cp.Snapshot) (cp.Snapshot, error) {
+	for _, dir := range []string{pmDir, proxyDir, loggerDir} {
+		names, err := getdirAt(sp, dir)
+		if err != nil {
+			return sp, err
+		}
+		for _, name := range names {
+			p := path.Join(dir, name)
+			if _, err := sp.Getdir(p); err == nil {
+				continue
+			}
+			val, _, err := sp.Get(p)
+			if err != nil {
+				return sp, err
+			}
+			fields := strings.Fields(val)
+			if len(fields) == 0 {
+				return sp, errorf(ErrInvalidFile, "invalid service entry %s", p)
+			}
+			attrs := serviceAttrs{Meta: map[string]string{}}
+			if len(fields) > 1 {
+				attrs.Version = fields[1]
+			}
+
+			if err := sp.Del(p); err != nil {
+				return sp, err
+			}
+			f, err := cp.NewFile(path.Join(p, serviceAttrsPath), attrs, new(cp.JsonCodec), sp).Save()
+			if err != nil {
+				return sp, err
+			}
+			sp = sp.Join(f)
+			for _, file := range []string{heartbeatPath, registeredPath} {
+				sp, err = sp.Set(path.Join(p, file), fields[0])
+				if err != nil {
+					return sp, err
+				}
+			}
+		}
+	}
+	return sp, nil
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers set by the WebhookSink.
+const (
+	HeaderEventRev  = "X-Visor-Event-Rev"
+	HeaderEventType = "X-Visor-Event-Type"
+	HeaderSignature = "X-Visor-Signature"
+)
+
+// Sink delivers Events out of process.
+type Sink interface {
+	// Send delivers the Event. RunSink stops at the first failed Send without
+	// saving its revision, so the Event is sent again once RunSink is
+	// restarted. Receivers can use the Rev of the Event to drop duplicates.
+	Send(ev *Event) error
+	Close() error
+}
+
+// RunSink delivers all Events matching filter to sink. It resumes after the
+// revision stored in c and saves the revision of every Event delivered, so
+// each Event is delivered at least once across restarts.
+func (s *Store) RunSink(sink Sink, c Checkpointer, filter ...EventType) error {
+	var (
+		ch   = make(chan *Event)
+		errc = make(chan error, 1)
+		done = make(chan struct{})
+	)
+	defer close(done)
+
+	go func() {
+		errc <- s.watchEventCheckpoint(c, ch, filter, done)
+	}()
+
+	for {
+		select {
+		case ev := <-ch:
+			if err := sink.Send(ev); err != nil {
+				return err
+			}
+			if err := c.Save(ev.Rev); err != nil {
+				return err
+			}
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+// WebhookSink posts Events in the JSON wire format to an HTTP endpoint.
+type WebhookSink struct {
+	URL string
+	// Secret signs the body with HMAC-SHA256, the hex encoded signature is
+	// sent in the X-Visor-Signature header. No signature is sent if empty.
+	Secret []byte
+	// Retries is the number of retries after a failed delivery.
+	Retries int
+	// Backoff is the wait before the first retry, it doubles for every
+	// further retry.
+	Backoff time.Duration
+	// DeadLetter receives Events which couldn't be delivered after all
+	// retries. Without DeadLetter, Send returns the delivery error.
+	DeadLetter Sink
+	Client     *http.Client
+}
+
+// NewWebhookSink returns a WebhookSink for the given url and secret with five
+// retries starting at one second backoff.
+func NewWebhookSink(url string, secret []byte) *WebhookSink {
+	return &WebhookSink{
+		URL:     url,
+		Secret:  secret,
+		Retries: 5,
+		Backoff: time.Second,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send satisfies the Sink interface.
+func (w *WebhookSink) Send(ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.Backoff
+	for i := 0; ; i++ {
+		err = w.post(ev, body)
+		if err == nil {
+			return nil
+		}
+		if i >= w.Retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if w.DeadLetter != nil {
+		return w.DeadLetter.Send(ev)
+	}
+	return err
+}
+
+// Close satisfies the Sink interface.
+func (w *WebhookSink) Close() error {
+	if w.DeadLetter != nil {
+		return w.DeadLetter.Close()
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body.
+func (w *WebhookSink) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, w.Secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *WebhookSink) post(ev *Event, body []byte) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventRev, strconv.FormatInt(ev.Rev, 10))
+	req.Header.Set(HeaderEventType, string(ev.Type))
+	if len(w.Secret) > 0 {
+		req.Header.Set(HeaderSignature, "sha256="+w.Sign(body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// FileSink appends Events in the JSON wire format as lines to a file. When
+// the file grows beyond MaxBytes it's rotated to path.1, path.1 to path.2 and
+// so on, keeping MaxFiles rotated files.
+type FileSink struct {
+	Path     string
+	MaxBytes int64
+	MaxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFileSink opens the FileSink for path. A MaxBytes of 0 disables rotation.
+func NewFileSink(path string, maxBytes int64, maxFiles int) (*FileSink, error) {
+	s := &FileSink{Path: path, MaxBytes: maxBytes, MaxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Send satisfies the Sink interface.
+func (s *FileSink) Send(ev *Event) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return errorf(ErrInvalidState, "sink %s is closed", s.Path)
+	}
+	if s.MaxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.MaxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close satisfies the Sink interface.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = fi.Size()
+	return nil
+}
+
+// rotate closes the file, shifts the rotated files and opens a new file. The
+// current file is reopened if shifting fails, so the sink stays usable.
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+
+	if err := s.shift(); err != nil {
+		if oerr := s.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	return s.open()
+}
+
+func (s *FileSink) shift() error {
+	if s.MaxFiles < 1 {
+		if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for i := s.MaxFiles - 1; i > 0; i-- {
+		err := os.Rename(s.rotatedPath(i), s.rotatedPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.Path, s.rotatedPath(1))
+}
+
+func (s *FileSink) rotatedPath(i int) string {
+	return s.Path + "." + strconv.Itoa(i)
+}
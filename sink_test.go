@@ -0,0 +1,244 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sinkEvent(rev int64) *Event {
+	app := "cat"
+	return &Event{Type: EvAppReg, Rev: rev, Path: EventData{App: &app}, Source: &App{Name: app}}
+}
+
+type failingSink struct {
+	err error
+}
+
+func (s *failingSink) Send(ev *Event) error { return s.err }
+func (s *failingSink) Close() error         { return nil }
+
+func sinkTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sink-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestWebhookSink(t *testing.T) {
+	var (
+		secret = []byte("s3cr3t")
+		sink   = NewWebhookSink("", secret)
+		calls  = 0
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "sha256="+sink.Sign(body), r.Header.Get(HeaderSignature); want != have {
+			t.Errorf("want signature %s, have %s", want, have)
+		}
+		if want, have := "4711", r.Header.Get(HeaderEventRev); want != have {
+			t.Errorf("want rev %s, have %s", want, have)
+		}
+		if want, have := string(EvAppReg), r.Header.Get(HeaderEventType); want != have {
+			t.Errorf("want type %s, have %s", want, have)
+		}
+
+		ev := &Event{}
+		if err := json.Unmarshal(body, ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Rev != 4711 || ev.Source.(*App).Name != "cat" {
+			t.Errorf("unexpected event %s", ev)
+		}
+	}))
+	defer srv.Close()
+
+	sink.URL = srv.URL
+	sink.Backoff = time.Millisecond
+
+	if err := sink.Send(sinkEvent(4711)); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 3, calls; want != have {
+		t.Errorf("want %d calls, have %d", want, have)
+	}
+}
+
+func TestWebhookSinkDeadLetter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, nil)
+	sink.Retries = 1
+	sink.Backoff = time.Millisecond
+
+	if err := sink.Send(sinkEvent(1)); err == nil {
+		t.Fatal("expected delivery to fail without dead letter")
+	}
+
+	dir := sinkTempDir(t)
+	defer os.RemoveAll(dir)
+
+	deadLetter, err := NewFileSink(filepath.Join(dir, "dead.jsonl"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.DeadLetter = deadLetter
+
+	if err := sink.Send(sinkEvent(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, deadLetter.Path)
+	if len(lines) != 1 || !strings.Contains(lines[0], `"rev":2`) {
+		t.Errorf("unexpected dead letters %#v", lines)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := sinkTempDir(t)
+	defer os.RemoveAll(dir)
+
+	line, err := json.Marshal(sinkEvent(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "events.jsonl")
+	sink, err := NewFileSink(path, int64(len(line)+1)*2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 7; i++ {
+		if err := sink.Send(sinkEvent(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, n := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		if want, have := n, len(readLines(t, file)); want != have {
+			t.Errorf("%s: want %d lines, have %d", file, want, have)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files, got %v", err)
+	}
+
+	if err := sink.Send(sinkEvent(8)); !IsErrInvalidState(err) {
+		t.Errorf("expected closed sink to fail, got %v", err)
+	}
+}
+
+func TestFileSinkRotationFailure(t *testing.T) {
+	dir := sinkTempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	sink, err := NewFileSink(path, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Send(sinkEvent(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A non-empty directory in the way of the rotated file fails the rename.
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(sinkEvent(2)); err == nil {
+		t.Fatal("expected rotation to fail")
+	}
+
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(sinkEvent(2)); err != nil {
+		t.Fatalf("expected sink to stay open after failed rotation, got %v", err)
+	}
+	if want, have := 1, len(readLines(t, path)); want != have {
+		t.Errorf("want %d lines, have %d", want, have)
+	}
+	if want, have := 1, len(readLines(t, path+".1")); want != have {
+		t.Errorf("want %d rotated lines, have %d", want, have)
+	}
+}
+
+func TestRunSinkFailure(t *testing.T) {
+	s, _ := eventSetup()
+	dir := sinkTempDir(t)
+	defer os.RemoveAll(dir)
+
+	c := NewFileCheckpoint(filepath.Join(dir, "rev"))
+	sink := &failingSink{err: errors.New("unreachable")}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.RunSink(sink, c, EvAppReg)
+	}()
+	if _, err := eventAppSetup(s, "sinkcat").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errc:
+		if err != sink.err {
+			t.Fatalf("want %v, have %v", sink.err, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected RunSink to stop at the failed send")
+	}
+	rev, err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 0 {
+		t.Errorf("expected failed event not to be checkpointed, got rev %d", rev)
+	}
+}
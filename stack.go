@@ -0,0 +1,410 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const stacksPath = "stacks"
+
+var reStackPart = regexp.MustCompile(`^[[:alnum:]][-_.[:alnum:]]*$`)
+
+// StackStatus is the lifecycle state of a Stack version.
+type StackStatus string
+
+// Stack statuses. Apps can be moved to current and deprecated stacks, but
+// not to removed ones.
+const (
+	StackCurrent    = StackStatus("current")
+	StackDeprecated = StackStatus("deprecated")
+	StackRemoved    = StackStatus("removed")
+)
+
+// Stack is a version of a base image apps are built and run on. Apps
+// reference it by name, or by name and version in the form "name:version".
+// The registry is opt-in: until the first Stack is registered the stacks of
+// Apps aren't validated at all. Stacks can only be changed by admins.
+type Stack struct {
+	file       *cp.File
+	identity   identity
+	Name       string      `json:"name"`
+	Version    string      `json:"version"`
+	Status     StackStatus `json:"status"`
+	Notes      string      `json:"notes"`
+	Registered time.Time   `json:"registered"`
+}
+
+// NewStack returns a new Stack given a name, version and release notes.
+func (s *Store) NewStack(name, version, notes string) *Stack {
+	return &Stack{
+		file:     cp.NewFile(path.Join(stacksPath, name, version), nil, new(cp.JsonCodec), s.GetSnapshot()),
+		identity: s.identity,
+		Name:     name,
+		Version:  version,
+		Status:   StackCurrent,
+		Notes:    notes,
+	}
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (st *Stack) GetSnapshot() cp.Snapshot {
+	return st.file.Snapshot
+}
+
+// Ref returns the reference to the Stack version used by Apps.
+func (st *Stack) Ref() string {
+	return st.Name + ":" + st.Version
+}
+
+// Register stores the Stack version in the registry. Registering the first
+// Stack enables validation of the stacks of Apps, see validateStackRef.
+func (st *Stack) Register() (*Stack, error) {
+	if err := authorizeAdmin(st, "register stacks"); err != nil {
+		return nil, err
+	}
+	if !reStackPart.MatchString(st.Name) || !reStackPart.MatchString(st.Version) {
+		return nil, errorf(ErrInvalidArgument, `invalid stack "%s"`, st.Ref())
+	}
+	if err := validateStackStatus(st.Status); err != nil {
+		return nil, err
+	}
+	sp, err := st.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	exists, _, err := sp.Exists(st.file.Path)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errorf(ErrConflict, `stack "%s" already exists`, st.Ref())
+	}
+
+	st.Registered = time.Now()
+
+	st.file, err = cp.NewFile(st.file.Path, st, new(cp.JsonCodec), sp).Save()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := audit(st, st.file.Path, AuditRegister, nil, st.Status); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Unregister removes the Stack version from the registry. It fails with
+// ErrConflict while apps reference it, archived ones included, mark it
+// removed instead.
+func (st *Stack) Unregister() error {
+	if err := authorizeAdmin(st, "unregister stacks"); err != nil {
+		return err
+	}
+	sp, err := st.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	if _, err := getStack(st.Name, st.Version, sp); err != nil {
+		return err
+	}
+	store := storeFromSnapshotable(st).join(sp)
+	apps, err := getAppsByStack(st.Ref(), store)
+	if err != nil {
+		return err
+	}
+	archived, err := store.GetArchivedApps()
+	if err != nil {
+		return err
+	}
+	for _, app := range archived {
+		if matchStackRef(app.Stack, st.Ref()) {
+			apps = append(apps, app)
+		}
+	}
+	if len(apps) > 0 {
+		return errorf(ErrConflict, `stack "%s" is used by %d apps`, st.Ref(), len(apps))
+	}
+	if err := sp.Del(st.file.Path); err != nil {
+		return err
+	}
+	return audit(st, st.file.Path, AuditUnregister, st.Status, nil)
+}
+
+// SetStatus changes the status of the Stack version. Apps already on a
+// removed stack keep running, but can't be registered with it.
+func (st *Stack) SetStatus(status StackStatus) (*Stack, error) {
+	if err := authorizeAdmin(st, "change stacks"); err != nil {
+		return nil, err
+	}
+	if err := validateStackStatus(status); err != nil {
+		return nil, err
+	}
+	sp, err := st.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := getStack(st.Name, st.Version, sp)
+	if err != nil {
+		return nil, err
+	}
+	old := stored.Status
+	st.Status = status
+
+	st.file, err = stored.file.Set(st)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := audit(st, st.file.Path, AuditSetAttrs, old, status); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *Stack) String() string {
+	return fmt.Sprintf("Stack<%s>{status: %s}", st.Ref(), st.Status)
+}
+
+// GetStack fetches the Stack with the given name and version.
+func (s *Store) GetStack(name, version string) (*Stack, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getStack(name, version, s.join(sp))
+}
+
+// GetStacks returns all registered Stack versions ordered by name and
+// registration time.
+func (s *Store) GetStacks() ([]*Stack, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getStacks(s.join(sp))
+}
+
+// GetStackVersions returns the registered versions of the named Stack
+// ordered by registration time.
+func (s *Store) GetStackVersions(name string) ([]*Stack, error) {
+	stacks, err := s.GetStacks()
+	if err != nil {
+		return nil, err
+	}
+	versions := []*Stack{}
+	for _, st := range stacks {
+		if st.Name == name {
+			versions = append(versions, st)
+		}
+	}
+	return versions, nil
+}
+
+// GetAppsByStack returns the Apps on the given stack. A reference without
+// version matches Apps on any version of the named stack.
+func (s *Store) GetAppsByStack(ref string) ([]*App, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getAppsByStack(ref, s.join(sp))
+}
+
+// StackMoveReport lists the Apps moved by MoveApps.
+type StackMoveReport struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	DryRun bool   `json:"dryRun"`
+	// Moved holds the names of the apps moved, or which would be moved on a
+	// dry run.
+	Moved []string `json:"moved"`
+	// Failed maps the names of the apps which couldn't be moved to the
+	// reason.
+	Failed map[string]string `json:"failed"`
+}
+
+// MoveApps moves all Apps on stack from to stack to, which has to be
+// registered and not removed. Apps which can't be moved, e.g. for lack of
+// permission, are skipped and reported. With dryRun set nothing is changed.
+func (s *Store) MoveApps(from, to string, dryRun bool) (*StackMoveReport, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateStackRef(to, sp); err != nil {
+		return nil, err
+	}
+	apps, err := getAppsByStack(from, s.join(sp))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StackMoveReport{
+		From:   from,
+		To:     to,
+		DryRun: dryRun,
+		Moved:  []string{},
+		Failed: map[string]string{},
+	}
+	for _, app := range apps {
+		if app.Stack == to {
+			continue
+		}
+		if dryRun {
+			err = authorize(app, app.Name, RoleOwner)
+		} else {
+			_, err = app.SetStack(to)
+		}
+		if err != nil {
+			report.Failed[app.Name] = err.Error()
+			continue
+		}
+		report.Moved = append(report.Moved, app.Name)
+	}
+	sort.Strings(report.Moved)
+
+	return report, nil
+}
+
+// ParseStackRef splits a stack reference into name and version. The
+// version is empty if the reference doesn't have one.
+func ParseStackRef(ref string) (name, version string) {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// matchStackRef returns true if the stack of an App matches ref.
+func matchStackRef(stack, ref string) bool {
+	name, version := ParseStackRef(ref)
+	if version != "" {
+		return stack == ref
+	}
+	n, _ := ParseStackRef(stack)
+	return n == name
+}
+
+// validateStackRef checks ref against the registry. It has to name a
+// registered stack version which isn't removed, or a stack with such a
+// version. As long as no stacks are registered every reference is valid, so
+// registries which don't use stacks keep accepting any.
+func validateStackRef(ref string, sp cp.Snapshot) error {
+	exists, _, err := sp.Exists(stacksPath)
+	if err != nil || !exists {
+		return err
+	}
+	name, version := ParseStackRef(ref)
+
+	versions := []string{version}
+	if version == "" {
+		versions, err = getdirAt(sp, path.Join(stacksPath, name))
+		if err != nil {
+			return err
+		}
+	}
+	var removed bool
+	for _, v := range versions {
+		st, err := getStack(name, v, sp)
+		if IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if st.Status != StackRemoved {
+			return nil
+		}
+		removed = true
+	}
+	if removed {
+		return errorf(ErrInvalidState, `stack "%s" is removed`, ref)
+	}
+	return errorf(ErrNotFound, `stack "%s" not found`, ref)
+}
+
+func validateStackStatus(status StackStatus) error {
+	switch status {
+	case StackCurrent, StackDeprecated, StackRemoved:
+		return nil
+	}
+	return errorf(ErrInvalidArgument, `invalid stack status "%s"`, status)
+}
+
+func getAppsByStack(ref string, s cp.Snapshotable) ([]*App, error) {
+	apps, err := getApps(s)
+	if err != nil {
+		return nil, err
+	}
+	matching := []*App{}
+	for _, app := range apps {
+		if matchStackRef(app.Stack, ref) {
+			matching = append(matching, app)
+		}
+	}
+	return matching, nil
+}
+
+func getStacks(s cp.Snapshotable) ([]*Stack, error) {
+	sp := s.GetSnapshot()
+	stacks := []*Stack{}
+
+	names, err := getdirAt(sp, stacksPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		versions, err := sp.Getdir(path.Join(stacksPath, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			st, err := getStack(name, version, s)
+			if err != nil {
+				return nil, err
+			}
+			stacks = append(stacks, st)
+		}
+	}
+	sort.Sort(stacksByRegistered(stacks))
+
+	return stacks, nil
+}
+
+func getStack(name, version string, s cp.Snapshotable) (*Stack, error) {
+	st := &Stack{}
+	f, err := s.GetSnapshot().GetFile(path.Join(stacksPath, name, version), &cp.JsonCodec{DecodedVal: st})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, `stack "%s:%s" not found`, name, version)
+		}
+		return nil, err
+	}
+	st.file = f
+	st.identity = identityOf(s)
+
+	return st, nil
+}
+
+type stacksByRegistered []*Stack
+
+func (s stacksByRegistered) Len() int      { return len(s) }
+func (s stacksByRegistered) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s stacksByRegistered) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].Registered.Before(s[j].Registered)
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func stackSetup() *Store {
+	s, err := DialURI(DefaultURI, "/stack-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestStackRegistry(t *testing.T) {
+	s := stackSetup()
+
+	// Without registered stacks any stack is accepted.
+	if _, err := s.NewApp("legacy", "git://legacy.git", "lucid").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.NewStack("precise", "1", "initial").Register(); !IsErrUnauthorized(err) {
+		t.Errorf("expected ErrUnauthorized without admin role, got %v", err)
+	}
+	admin := s.With(WithAdmin())
+	old, err := admin.NewStack("precise", "1", "initial").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.NewStack("precise", "1", "again").Register(); !IsErrConflict(err) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if _, err := admin.NewStack("precise", "2", "openssl").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.NewApp("cat", "git://cat.git", "precise:1").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewApp("dog", "git://dog.git", "precise").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewApp("fox", "git://fox.git", "precise:3").Register(); !IsErrNotFound(err) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := old.SetStatus(StackRemoved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewApp("fox", "git://fox.git", "precise:1").Register(); !IsErrInvalidState(err) {
+		t.Errorf("expected ErrInvalidState for removed stack, got %v", err)
+	}
+	cat, err := s.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat.Team = "pets"
+	if _, err := cat.StoreAttrs(); err != nil {
+		t.Errorf("expected app on removed stack to keep its stack, got %v", err)
+	}
+
+	versions, err := s.GetStackVersions("precise")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != "1" || versions[0].Status != StackRemoved {
+		t.Errorf("unexpected versions %v", versions)
+	}
+
+	apps, err := s.GetAppsByStack("precise")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Errorf("expected cat and dog on precise, got %v", apps)
+	}
+	if err := old.Unregister(); !IsErrConflict(err) {
+		t.Errorf("expected ErrConflict for stack in use, got %v", err)
+	}
+
+	// Archived apps keep their stack in use.
+	if _, err := admin.NewStack("trusty", "1", "").Register(); err != nil {
+		t.Fatal(err)
+	}
+	cat, err = s.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cat.SetStack("precise:2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewApp("owl", "git://owl.git", "precise:2").Register(); err != nil {
+		t.Fatal(err)
+	}
+	unused, err := admin.GetStack("precise", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unused.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cat", "dog", "owl"} {
+		app, err := s.GetApp(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "owl" {
+			_, err = app.Archive()
+		} else {
+			_, err = app.SetStack("trusty:1")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, err := s.GetStack("precise", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Unregister(); !IsErrUnauthorized(err) {
+		t.Errorf("expected ErrUnauthorized without admin role, got %v", err)
+	}
+	if _, err := st.SetStatus(StackDeprecated); !IsErrUnauthorized(err) {
+		t.Errorf("expected ErrUnauthorized without admin role, got %v", err)
+	}
+	st, err = admin.GetStack("precise", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Unregister(); !IsErrConflict(err) {
+		t.Errorf("expected ErrConflict for stack of archived app, got %v", err)
+	}
+}
+
+func TestStackMoveApps(t *testing.T) {
+	s := stackSetup()
+
+	for _, v := range []string{"1", "2"} {
+		if _, err := s.With(WithAdmin()).NewStack("precise", v, "").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"cat", "dog"} {
+		if _, err := s.NewApp(name, "git://"+name+".git", "precise:1").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locked := s.NewApp("fox", "git://fox.git", "precise:1")
+	locked.ACL = &ACL{Owners: []string{"alice"}}
+	if _, err := locked.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.MoveApps("precise:1", "precise:3", true); !IsErrNotFound(err) {
+		t.Errorf("expected ErrNotFound for unknown target, got %v", err)
+	}
+
+	bob := s.With(WithClient("bob"))
+	report, err := bob.MoveApps("precise:1", "precise:2", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"cat", "dog"}, report.Moved) || len(report.Failed) != 1 {
+		t.Errorf("unexpected dry run report %#v", report)
+	}
+	apps, err := s.GetAppsByStack("precise:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 0 {
+		t.Errorf("expected dry run not to move apps, got %v", apps)
+	}
+
+	report, err = bob.MoveApps("precise:1", "precise:2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := report.Failed["fox"]; !ok || len(report.Moved) != 2 {
+		t.Errorf("unexpected report %#v", report)
+	}
+	apps, err = s.GetAppsByStack("precise:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Errorf("expected cat and dog on precise:2, got %v", apps)
+	}
+}
+
+func TestStackRefMatch(t *testing.T) {
+	for _, c := range []struct {
+		stack, ref string
+		want       bool
+	}{
+		{"precise:1", "precise", true},
+		{"precise", "precise", true},
+		{"precise:1", "precise:1", true},
+		{"precise:1", "precise:2", false},
+		{"precise", "precise:1", false},
+		{"precise64", "precise", false},
+	} {
+		if have := matchStackRef(c.stack, c.ref); c.want != have {
+			t.Errorf("%s ~ %s: want %t, have %t", c.stack, c.ref, c.want, have)
+		}
+	}
+}
@@ -43,7 +43,10 @@ func (t *Tag) GetSnapshot() cp.Snapshot {
 // Register stores the Tag in store. It does permit overwriting an existing tag
 // with the same name to enable atomic updates.
 func (t *Tag) Register() error {
-	var err error
+	err := authorize(t, t.App.Name, RoleDeployer)
+	if err != nil {
+		return err
+	}
 
 	revs, err := t.App.GetRevisions()
 	if err != nil {
@@ -63,16 +66,27 @@ func (t *Tag) Register() error {
 		return errorf(ErrNotFound, `revision "%s" not found for app "%s"`, t.Ref, t.App.Name)
 	}
 
+	var old interface{}
+	prev, err := t.App.GetTag(t.Name)
+	if err == nil {
+		old = prev.Ref
+	} else if !IsErrNotFound(err) {
+		return err
+	}
+
 	t.Registered = time.Now()
 	t.file, err = t.file.Set(t)
 	if err != nil {
 		return err
 	}
-	return nil
+	return audit(t, t.file.Path, AuditRegister, old, t.Ref)
 }
 
 // Unregister removes the stored Tag from store.
 func (t *Tag) Unregister() error {
+	if err := authorize(t, t.App.Name, RoleDeployer); err != nil {
+		return err
+	}
 	sp, err := t.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -84,7 +98,10 @@ func (t *Tag) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `tag "%s" not found`, t.Name)
 	}
-	return t.file.Del()
+	if err := t.file.Del(); err != nil {
+		return err
+	}
+	return audit(t, t.file.Path, AuditUnregister, t.Ref, nil)
 }
 
 // GetTag retrieves the Tag with the given name.
@@ -118,7 +135,10 @@ func (a *App) GetTags() ([]*Tag, error) {
 	if err != nil {
 		return nil, err
 	}
+	return a.getTags(sp)
+}
 
+func (a *App) getTags(sp cp.Snapshot) ([]*Tag, error) {
 	names, err := sp.Getdir(a.dir.Prefix(tagsPath))
 	if err != nil {
 		return nil, err
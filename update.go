@@ -0,0 +1,137 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// maxUpdateAttempts bounds the number of times UpdateAttrs applies its
+// modify function.
+const maxUpdateAttempts = 5
+
+// UpdateAttrs reads the latest App, applies modify to it and stores its
+// attrs. It starts over with the App stored by the concurrent writer as long
+// as storing fails with ErrStaleWrite, at most maxUpdateAttempts times.
+// Errors returned by modify are returned as is.
+func (a *App) UpdateAttrs(modify func(*App) error) (*App, error) {
+	var app *App
+	err := retryStale(func() error {
+		sp, err := a.GetSnapshot().FastForward()
+		if err != nil {
+			return err
+		}
+		app, err = getApp(a.Name, storeFromSnapshotable(a).join(sp))
+		if err != nil {
+			return err
+		}
+		if err := modify(app); err != nil {
+			return err
+		}
+		app, err = app.StoreAttrs()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// UpdateAttrs reads the latest Proc, applies modify to it and stores its
+// attrs, see App.UpdateAttrs.
+func (p *Proc) UpdateAttrs(modify func(*Proc) error) (*Proc, error) {
+	var proc *Proc
+	err := retryStale(func() error {
+		sp, err := p.GetSnapshot().FastForward()
+		if err != nil {
+			return err
+		}
+		proc, err = getProc(p.App, p.Name, sp)
+		if err != nil {
+			return err
+		}
+		if err := modify(proc); err != nil {
+			return err
+		}
+		proc, err = proc.StoreAttrs()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proc, nil
+}
+
+func retryStale(fn func() error) (err error) {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		if err = fn(); !IsErrStaleWrite(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// checkUnmodified returns ErrStaleWrite if the JSON file at path changed
+// after the revision of read, listing the top-level fields which differ
+// from the ones at read. All fields are listed if that revision isn't
+// available anymore.
+func checkUnmodified(what, path string, read, sp cp.Snapshot) error {
+	after, rev, err := sp.Get(path)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil
+		}
+		return err
+	}
+	if rev <= read.Rev {
+		return nil
+	}
+	before, _, err := read.Get(path)
+	if err != nil {
+		before = ""
+	}
+	return errorf(
+		ErrStaleWrite,
+		"%s changed at revision %d after being read at %d: %s",
+		what, rev, read.Rev, strings.Join(changedFields(before, after), ", "),
+	)
+}
+
+// staleErr converts revision mismatches of a write racing another one to
+// ErrStaleWrite.
+func staleErr(what string, err error) error {
+	if cp.IsErrRevMismatch(err) {
+		return errorf(ErrStaleWrite, "%s changed concurrently", what)
+	}
+	return err
+}
+
+// changedFields returns the sorted names of the top-level fields which
+// differ between the two JSON objects.
+func changedFields(before, after string) []string {
+	var b, a map[string]interface{}
+	json.Unmarshal([]byte(before), &b)
+	json.Unmarshal([]byte(after), &a)
+
+	fields := []string{}
+	for k, v := range a {
+		if !reflect.DeepEqual(b[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
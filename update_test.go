@@ -0,0 +1,130 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func updateSetup() (*Store, *App) {
+	s, err := DialURI(DefaultURI, "/update-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		panic(err)
+	}
+	return s, app
+}
+
+func TestAppStoreAttrsStale(t *testing.T) {
+	s, _ := updateSetup()
+
+	alice, err := s.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := s.GetApp("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice.Team = "pets"
+	if _, err := alice.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	alice.Owner = "alice"
+	if _, err := alice.StoreAttrs(); err != nil {
+		t.Fatalf("expected consecutive writes to succeed, got %s", err)
+	}
+
+	bob.RepoURL = "git://kitten.git"
+	_, err = bob.StoreAttrs()
+	if !IsErrStaleWrite(err) {
+		t.Fatalf("expected ErrStaleWrite, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "owner, team") {
+		t.Errorf("expected changed fields in %q", err)
+	}
+
+	app, err := bob.UpdateAttrs(func(a *App) error {
+		a.RepoURL = "git://kitten.git"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.RepoURL != "git://kitten.git" || app.Team != "pets" {
+		t.Errorf("expected update on top of the latest attrs, got %#v", app)
+	}
+}
+
+func TestProcUpdateAttrs(t *testing.T) {
+	s, app := updateSetup()
+
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := app.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit := 512
+	proc.Attrs.Limits.MemoryLimitMb = &limit
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	stale, err = stale.UpdateAttrs(func(p *Proc) error {
+		count++
+		p.Attrs.Limits.MemoryLimitMb = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || stale.Attrs.Limits.MemoryLimitMb != nil {
+		t.Errorf("unexpected update after %d attempts: %#v", count, stale.Attrs)
+	}
+
+	if _, err := proc.StoreAttrs(); !IsErrStaleWrite(err) {
+		t.Errorf("expected ErrStaleWrite, got %v", err)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	for _, c := range []struct {
+		before, after string
+		want          []string
+	}{
+		{`{"a":1,"b":"x"}`, `{"a":1,"b":"x"}`, []string{}},
+		{`{"a":1,"b":"x"}`, `{"a":2,"c":"x"}`, []string{"a", "b", "c"}},
+		{`{"a":{"b":1}}`, `{"a":{"b":2}}`, []string{"a"}},
+		{``, `{"a":1}`, []string{"a"}},
+	} {
+		if have := changedFields(c.before, c.after); !reflect.DeepEqual(c.want, have) {
+			t.Errorf("%s -> %s: want %v, have %v", c.before, c.after, c.want, have)
+		}
+	}
+}
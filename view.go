@@ -0,0 +1,161 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// View is a read-only view of the domain model at a fixed revision. Unlike
+// the getters of Store and App, its getters don't fast-forward. Methods of
+// the returned objects do fast-forward, they operate on the current state.
+type View struct {
+	snapshot cp.Snapshot
+	identity identity
+}
+
+// AtRev returns a View of the store at the given revision. Reads return
+// ErrRevCompacted once the coordinator dropped the revision from its history.
+func (s *Store) AtRev(rev int64) (*View, error) {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	if rev < 1 || rev > sp.Rev {
+		return nil, errorf(ErrInvalidArgument, "invalid revision %d, current is %d", rev, sp.Rev)
+	}
+	sp.Rev = rev
+	return &View{snapshot: sp, identity: s.identity}, nil
+}
+
+// Rev returns the revision of the View.
+func (v *View) Rev() int64 {
+	return v.snapshot.Rev
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (v *View) GetSnapshot() cp.Snapshot {
+	return v.snapshot
+}
+
+// GetApp returns the App with the given name as of the View's revision.
+func (v *View) GetApp(name string) (*App, error) {
+	app, err := getApp(name, v)
+	return app, v.err(err)
+}
+
+// GetApps returns all Apps registered at the View's revision.
+func (v *View) GetApps() ([]*App, error) {
+	apps, err := getApps(v)
+	return apps, v.err(err)
+}
+
+// GetRevisions returns the Revisions of the given app.
+func (v *View) GetRevisions(app string) ([]*Revision, error) {
+	a, err := v.GetApp(app)
+	if err != nil {
+		return nil, err
+	}
+	revs, err := a.getRevisions(v.snapshot)
+	return revs, v.err(err)
+}
+
+// GetProc returns the given Proc of the given app.
+func (v *View) GetProc(app, name string) (*Proc, error) {
+	a, err := v.GetApp(app)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := getProc(a, name, v.snapshot)
+	return proc, v.err(err)
+}
+
+// GetProcs returns the Procs of the given app.
+func (v *View) GetProcs(app string) ([]*Proc, error) {
+	a, err := v.GetApp(app)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := a.getProcs(v.snapshot)
+	return procs, v.err(err)
+}
+
+// GetEnv returns the Env with the given ref of the given app. Like
+// App.GetEnv it requires the viewer role for apps with an ACL, as of the
+// latest revision.
+func (v *View) GetEnv(app, ref string) (*Env, error) {
+	a, err := v.GetApp(app)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(a, app, RoleViewer); err != nil {
+		return nil, err
+	}
+	env, err := getEnv(a, ref, v.snapshot)
+	return env, v.err(err)
+}
+
+// GetEnvs returns the Envs of the given app.
+func (v *View) GetEnvs(app string) ([]*Env, error) {
+	a, err := v.GetApp(app)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(a, app, RoleViewer); err != nil {
+		return nil, err
+	}
+	envs, err := a.getEnvs(v.snapshot)
+	return envs, v.err(err)
+}
+
+// GetTag returns the Tag with the given name of the given app.
+func (v *View) GetTag(app, name string) (*Tag, error) {
+	a, err := v.GetApp(app)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := getTag(a, name, v.snapshot)
+	return tag, v.err(err)
+}
+
+// GetTags returns the Tags of the given app.
+func (v *View) GetTags(app string) ([]*Tag, error) {
+	a, err := v.GetApp(app)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := a.getTags(v.snapshot)
+	return tags, v.err(err)
+}
+
+// GetInstance returns the Instance with the given id.
+func (v *View) GetInstance(id int64) (*Instance, error) {
+	ins, err := getInstance(id, v)
+	return ins, v.err(err)
+}
+
+// GetInstances returns all Instances existing at the View's revision.
+func (v *View) GetInstances() ([]*Instance, error) {
+	instances, err := getInstances(v)
+	return instances, v.err(err)
+}
+
+// GetProcInstances returns the Instances of the given proc.
+func (v *View) GetProcInstances(app, proc string) ([]*Instance, error) {
+	p, err := v.GetProc(app, proc)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := p.getInstances(v.snapshot)
+	return instances, v.err(err)
+}
+
+func (v *View) err(err error) error {
+	if err == nil {
+		return nil
+	}
+	return revErr(err, v.snapshot.Rev)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+)
+
+func viewSetup() *Store {
+	s, err := DialURI(DefaultURI, "/view-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestViewAtRev(t *testing.T) {
+	s := viewSetup()
+
+	app, err := s.NewApp("cat", "git://cat.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewProc(app, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+	env, err := app.NewEnv("prod", map[string]string{"A": "1"}).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance("cat", "abc", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := s.GetSnapshot().Rev
+
+	if _, err := ins.Claim("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Unregister(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterInstance("cat", "abc", "web", "prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.AtRev(rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := rev, v.Rev(); want != have {
+		t.Errorf("want rev %d, have %d", want, have)
+	}
+
+	env1, err := v.GetEnv("cat", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "1", env1.Vars["A"]; want != have {
+		t.Errorf("want var %s, have %s", want, have)
+	}
+
+	instances, err := v.GetProcInstances("cat", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("expected 1 instance at rev %d, got %d", rev, len(instances))
+	}
+	if want, have := InsStatusPending, instances[0].Status; want != have {
+		t.Errorf("want status %s, have %s", want, have)
+	}
+
+	if _, err := app.GetEnv("prod"); !IsErrNotFound(err) {
+		t.Errorf("expected env to be gone at the current rev, got %v", err)
+	}
+
+	if _, err := s.AtRev(rev + 1000); !IsErrInvalidArgument(err) {
+		t.Errorf("expected future revision to be rejected, got %v", err)
+	}
+}
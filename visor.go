@@ -7,10 +7,7 @@ package visor
 
 import (
 	"fmt"
-	"net"
-	"path"
 	"strconv"
-	"strings"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
@@ -18,7 +15,7 @@ import (
 
 // SegenaVersion encodes the expected tree layout and MUST be increased
 // whenever breaking changes are introduced.
-const SchemaVersion = 7
+const SchemaVersion = 9
 
 // Defaults and paths
 const (
@@ -39,15 +36,42 @@ var Version string
 // Store is the representation of the coordinator tree.
 type Store struct {
 	snapshot cp.Snapshot
+	identity identity
+}
+
+// StoreOption configures a Store.
+type StoreOption func(*Store)
+
+// WithClient sets the client identity of the Store and the objects obtained
+// from it. It's the principal checked against App ACLs and recorded in the
+// audit log.
+func WithClient(client string) StoreOption {
+	return func(s *Store) {
+		s.identity.client = client
+	}
 }
 
 // DialURI sets up a new Store.
-func DialURI(uri, root string) (*Store, error) {
+func DialURI(uri, root string, opts ...StoreOption) (*Store, error) {
 	sp, err := cp.DialUri(uri, root)
 	if err != nil {
 		return nil, err
 	}
-	return &Store{sp}, nil
+	return (&Store{snapshot: sp}).With(opts...), nil
+}
+
+// With returns a copy of the Store with the given options applied.
+func (s *Store) With(opts ...StoreOption) *Store {
+	s1 := &Store{snapshot: s.snapshot, identity: s.identity}
+	for _, opt := range opts {
+		opt(s1)
+	}
+	return s1
+}
+
+// Client returns the client identity of the Store.
+func (s *Store) Client() string {
+	return s.identity.client
 }
 
 // GetSnapshot satisfies the cp.Snapshotable interface.
@@ -61,7 +85,7 @@ func (s *Store) FastForward() (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Store{sp}, nil
+	return s.join(sp), nil
 }
 
 // Init sets up expected paths.
@@ -89,6 +113,16 @@ func (s *Store) Init() (*Store, error) {
 		if err != nil {
 			return nil, err
 		}
+	} else if cp.IsErrSchemaMism(err) && (v == 7 || v == 8) {
+		// Schema 9 moved pms, proxies and loggers to their own directories.
+		sp, err = migrateServices(sp)
+		if err != nil {
+			return nil, err
+		}
+		sp, err = cp.SetSchemaVersion(SchemaVersion, sp)
+		if err != nil {
+			return nil, err
+		}
 	} else if err != nil {
 		if cp.IsErrSchemaMism(err) {
 			err = fmt.Errorf("%s (%d != %d)", err, SchemaVersion, v)
@@ -101,40 +135,6 @@ func (s *Store) Init() (*Store, error) {
 	return s, nil
 }
 
-// GetLoggers gets the list of bazooka-log services endpoints.
-func (s *Store) GetLoggers() ([]string, error) {
-	sp, err := s.GetSnapshot().FastForward()
-	if err != nil {
-		return nil, err
-	}
-	names, err := sp.Getdir(loggerDir)
-	if err != nil {
-		return nil, err
-	}
-	for i, name := range names {
-		names[i] = strings.Replace(name, "-", ":", 1)
-	}
-	return names, nil
-}
-
-// GetProxies gets the list of bazooka-proxy service IPs
-func (s *Store) GetProxies() ([]string, error) {
-	sp, err := s.GetSnapshot().FastForward()
-	if err != nil {
-		return nil, err
-	}
-	return sp.Getdir(proxyDir)
-}
-
-// GetPms gets the list of bazooka-pm service IPs
-func (s *Store) GetPms() ([]string, error) {
-	sp, err := s.GetSnapshot().FastForward()
-	if err != nil {
-		return nil, err
-	}
-	return sp.Getdir(pmDir)
-}
-
 // GetAppNames returns names of all registered apps.
 func (s *Store) GetAppNames() ([]string, error) {
 	sp, err := s.GetSnapshot().FastForward()
@@ -144,54 +144,6 @@ func (s *Store) GetAppNames() ([]string, error) {
 	return sp.Getdir("apps")
 }
 
-// RegisterLogger given an address and a version stores the Logger.
-func (s *Store) RegisterLogger(addr, version string) (*Store, error) {
-	host, port, err := net.SplitHostPort(addr)
-	if err != nil {
-		return nil, err
-	}
-	sp, err := s.GetSnapshot().Set(path.Join(loggerDir, host+"-"+port), timestamp()+" "+version)
-	if err != nil {
-		return nil, err
-	}
-	s.snapshot = sp
-	return s, nil
-}
-
-// UnregisterLogger removes the logger for the given address from the store.
-func (s *Store) UnregisterLogger(addr string) error {
-	host, port, err := net.SplitHostPort(addr)
-	if err != nil {
-		return err
-	}
-	return s.GetSnapshot().Del(path.Join(loggerDir, host+"-"+port))
-}
-
-// RegisterPm stores the pm for the given host.
-func (s *Store) RegisterPm(host, version string) (*Store, error) {
-	sp, err := s.GetSnapshot().Set(path.Join(pmDir, host), timestamp()+" "+version)
-	if err != nil {
-		return nil, err
-	}
-	s.snapshot = sp
-	return s, nil
-}
-
-// UnregisterPm removes the pm for the given host.
-func (s *Store) UnregisterPm(host string) error {
-	return s.GetSnapshot().Del(path.Join(pmDir, host))
-}
-
-// RegisterProxy stores the proxy for the given host.
-func (s *Store) RegisterProxy(host string) (*Store, error) {
-	sp, err := s.GetSnapshot().Set(path.Join(proxyDir, host), timestamp())
-	if err != nil {
-		return nil, err
-	}
-	s.snapshot = sp
-	return s, nil
-}
-
 // SetSchemaVersion is used to update the store schema which is used for
 // validation.
 func (s *Store) SetSchemaVersion(version int) error {
@@ -222,17 +174,17 @@ func (s *Store) VerifySchema() (int, error) {
 	return v, nil
 }
 
-// UnregisterProxy removes the proxy for the given host from the store.
-func (s *Store) UnregisterProxy(host string) error {
-	return s.GetSnapshot().Del(path.Join(proxyDir, host))
-}
-
 func (s *Store) reset() error {
 	return s.GetSnapshot().Reset()
 }
 
 func storeFromSnapshotable(sp cp.Snapshotable) *Store {
-	return &Store{sp.GetSnapshot()}
+	return &Store{snapshot: sp.GetSnapshot(), identity: identityOf(sp)}
+}
+
+// join returns a Store at the given snapshot keeping the identity of s.
+func (s *Store) join(sp cp.Snapshot) *Store {
+	return &Store{snapshot: sp, identity: s.identity}
 }
 
 func formatTime(t time.Time) string {